@@ -0,0 +1,216 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Firm Aggregation
+// Summaries built from the brokers' current employments, keyed by firm CRD
+// (falling back to the firm name when the CRD is missing).
+
+// firmSummary is one row of the firm-level rollup
+type firmSummary struct {
+	FirmCRD  string
+	FirmName string
+	Brokers  map[string]bool // CRDs of brokers currently at the firm
+	Cities   map[string]bool
+	States   map[string]bool
+}
+
+// firmKey identifies the firm of an employment
+func firmKey(emp Employment) string {
+	if emp.FirmCRD != "" {
+		return emp.FirmCRD
+	}
+	return "name:" + emp.FirmName
+}
+
+// groupByFirm rolls the brokers up by current firm, largest first
+func groupByFirm(data []BrokerSource) []*firmSummary {
+	firms := make(map[string]*firmSummary)
+	for _, broker := range data {
+		for _, emp := range broker.CurrentEmployments {
+			key := firmKey(emp)
+			f, ok := firms[key]
+			if !ok {
+				f = &firmSummary{
+					FirmCRD:  emp.FirmCRD,
+					FirmName: emp.FirmName,
+					Brokers:  make(map[string]bool),
+					Cities:   make(map[string]bool),
+					States:   make(map[string]bool),
+				}
+				firms[key] = f
+			}
+			f.Brokers[broker.CRD] = true
+			if emp.City != "" {
+				f.Cities[emp.City] = true
+			}
+			if emp.State != "" {
+				f.States[emp.State] = true
+			}
+		}
+	}
+
+	list := make([]*firmSummary, 0, len(firms))
+	for _, f := range firms {
+		list = append(list, f)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if len(list[i].Brokers) != len(list[j].Brokers) {
+			return len(list[i].Brokers) > len(list[j].Brokers)
+		}
+		return list[i].FirmName < list[j].FirmName
+	})
+	return list
+}
+
+// sortedKeys returns the keys of a set in order
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for k := range set {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// saveFirmSummary writes one row per firm with its broker count and the
+// cities and states of its branches (semicolon-separated)
+func saveFirmSummary(data []BrokerSource, filename string, comma rune) (int, error) {
+	file, err := createOutput(filename)
+	if err != nil {
+		logErrorf("Error creating CSV file: %v", err)
+		return 0, err
+	}
+	defer file.Close()
+
+	writer := csv.NewWriter(file)
+	if comma != 0 {
+		writer.Comma = comma
+	}
+	defer writer.Flush()
+
+	writer.Write([]string{"FirmCRD", "FirmName", "BrokerCount", "Cities", "States"})
+	firms := groupByFirm(data)
+	for _, f := range firms {
+		writer.Write([]string{
+			f.FirmCRD,
+			f.FirmName,
+			strconv.Itoa(len(f.Brokers)),
+			strings.Join(sortedKeys(f.Cities), ";"),
+			strings.Join(sortedKeys(f.States), ";"),
+		})
+	}
+
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		logErrorf("Error writing CSV file: %v", err)
+		return 0, err
+	}
+	if err := file.Commit(); err != nil {
+		logErrorf("Error writing CSV file: %v", err)
+		return 0, err
+	}
+	log.Printf("Successfully saved to %s", filename)
+	return len(firms), nil
+}
+
+// saveFirmLocations writes the distinct (firm, city, state, zip) tuples of
+// the brokers' current employments, without any broker columns
+func saveFirmLocations(data []BrokerSource, filename string, comma rune) (int, error) {
+	file, err := createOutput(filename)
+	if err != nil {
+		logErrorf("Error creating CSV file: %v", err)
+		return 0, err
+	}
+	defer file.Close()
+
+	writer := csv.NewWriter(file)
+	if comma != 0 {
+		writer.Comma = comma
+	}
+	defer writer.Flush()
+
+	writer.Write([]string{"FirmCRD", "FirmName", "FirmCity", "FirmState", "FirmZip"})
+	seen := make(map[Employment]bool)
+	for _, broker := range data {
+		for _, emp := range broker.CurrentEmployments {
+			loc := Employment{FirmCRD: emp.FirmCRD, FirmName: emp.FirmName, City: emp.City, State: emp.State, Zip: emp.Zip}
+			if seen[loc] {
+				continue
+			}
+			seen[loc] = true
+			writer.Write([]string{loc.FirmCRD, loc.FirmName, loc.City, loc.State, loc.Zip})
+		}
+	}
+
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		logErrorf("Error writing CSV file: %v", err)
+		return 0, err
+	}
+	if err := file.Commit(); err != nil {
+		logErrorf("Error writing CSV file: %v", err)
+		return 0, err
+	}
+	log.Printf("Successfully saved %d firm locations to %s", len(seen), filename)
+	return len(seen), nil
+}
+
+// firmCounts counts distinct brokers per current firm name (the firm CRD
+// when there is no name)
+func firmCounts(data []BrokerSource) map[string]int {
+	brokers := make(map[string]map[string]bool)
+	for _, broker := range data {
+		for _, emp := range broker.CurrentEmployments {
+			key := emp.FirmName
+			if key == "" {
+				key = emp.FirmCRD
+			}
+			if key == "" {
+				continue
+			}
+			if brokers[key] == nil {
+				brokers[key] = make(map[string]bool)
+			}
+			brokers[key][broker.CRD] = true
+		}
+	}
+	counts := make(map[string]int, len(brokers))
+	for firm, crds := range brokers {
+		counts[firm] = len(crds)
+	}
+	return counts
+}
+
+// saveFirmCounts writes a JSON object of firm name to broker count, with
+// the keys sorted so successive runs diff cleanly
+func saveFirmCounts(data []BrokerSource, filename string) (int, error) {
+	file, err := createOutput(filename)
+	if err != nil {
+		logErrorf("Error creating firm counts file: %v", err)
+		return 0, err
+	}
+	defer file.Close()
+
+	counts := firmCounts(data)
+	enc := json.NewEncoder(file)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false) // Firm names like "&PARTNERS" stay readable
+	if err := enc.Encode(counts); err != nil {
+		logErrorf("Error writing firm counts file: %v", err)
+		return 0, err
+	}
+	if err := file.Commit(); err != nil {
+		logErrorf("Error writing firm counts file: %v", err)
+		return 0, err
+	}
+	log.Printf("Successfully saved counts for %d firms to %s", len(counts), describeOutput(filename))
+	return len(counts), nil
+}
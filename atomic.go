@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"io/fs"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// Atomic Output Files
+// Outputs are written to a temp file next to the destination and renamed
+// into place only once complete, so a killed run never leaves a
+// half-written brokers.csv for a downstream job to read. Writes are
+// buffered (-output-buffer-size), which matters on network filesystems
+// where every small write is a round trip.
+
+// defaultOutputBuffer is -output-buffer-size's default, in bytes
+const defaultOutputBuffer = 256 << 10
+
+// outputBufferSize is how many bytes each output buffers before writing
+// through; 0 writes straight through. Set from -output-buffer-size.
+var outputBufferSize = defaultOutputBuffer
+
+// newOutputBuffer buffers w by outputBufferSize, or returns nil when
+// buffering is off
+func newOutputBuffer(w io.Writer) *bufio.Writer {
+	if outputBufferSize <= 0 {
+		return nil
+	}
+	return bufio.NewWriterSize(w, outputBufferSize)
+}
+
+// output is an open output file. Commit makes it visible under its final
+// name; Close without Commit throws the partial write away.
+type output interface {
+	io.WriteCloser
+	Commit() error
+}
+
+// atomicFile is an output backed by a temp file in the destination's
+// directory (rename is only atomic within one filesystem)
+type atomicFile struct {
+	file *os.File
+	buf  *bufio.Writer // nil when unbuffered
+	path string
+	done bool
+}
+
+func createAtomic(filename string) (*atomicFile, error) {
+	dir, base := filepath.Split(filename)
+	if dir == "" {
+		dir = "."
+	}
+	tmp, err := createTemp(dir, "."+base+".tmp-")
+	if err != nil {
+		return nil, err
+	}
+	// Replacing a file keeps its permissions, as writing over it would
+	if info, err := os.Stat(filename); err == nil && info.Mode().IsRegular() {
+		if err := tmp.Chmod(info.Mode().Perm()); err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+			return nil, err
+		}
+	}
+	return &atomicFile{file: tmp, buf: newOutputBuffer(tmp), path: filename}, nil
+}
+
+// createTemp is os.CreateTemp with the permissions os.Create gives (0666
+// less the umask) instead of 0600
+func createTemp(dir, prefix string) (*os.File, error) {
+	for range 100 {
+		name := filepath.Join(dir, prefix+strconv.FormatUint(rand.Uint64(), 36))
+		f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
+		if !os.IsExist(err) {
+			return f, err
+		}
+	}
+	return nil, &fs.PathError{Op: "createtemp", Path: filepath.Join(dir, prefix+"*"), Err: fs.ErrExist}
+}
+
+func (f *atomicFile) Write(p []byte) (int, error) {
+	if f.buf == nil {
+		return f.file.Write(p)
+	}
+	return f.buf.Write(p)
+}
+
+// Commit flushes the buffer and the temp file to disk and renames it over
+// the destination
+func (f *atomicFile) Commit() error {
+	if f.done {
+		return nil
+	}
+	f.done = true
+	if f.buf != nil {
+		if err := f.buf.Flush(); err != nil {
+			f.file.Close()
+			os.Remove(f.file.Name())
+			return err
+		}
+	}
+	if err := f.file.Sync(); err != nil {
+		f.file.Close()
+		os.Remove(f.file.Name())
+		return err
+	}
+	if err := f.file.Close(); err != nil {
+		os.Remove(f.file.Name())
+		return err
+	}
+	if err := os.Rename(f.file.Name(), f.path); err != nil {
+		os.Remove(f.file.Name())
+		return err
+	}
+	return nil
+}
+
+// Close discards the temp file unless it was committed
+func (f *atomicFile) Close() error {
+	if f.done {
+		return nil
+	}
+	f.done = true
+	err := f.file.Close()
+	os.Remove(f.file.Name())
+	return err
+}
+
+// writeFileAtomic is os.WriteFile by way of a temp file and rename
+func writeFileAtomic(filename string, data []byte) error {
+	out, err := createOutput(filename)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	if _, err := out.Write(data); err != nil {
+		return err
+	}
+	return out.Commit()
+}
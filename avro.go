@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"log"
+	"math"
+	"reflect"
+	"strings"
+)
+
+// Avro Output
+// -format avro writes brokers.avro, an Avro Object Container File: the
+// schema travels in the file header, followed by blocks of binary-encoded
+// records. Like schema.json, the Avro schema is built by reflection from
+// the BrokerSource and Employment struct tags, with the JSON field names.
+// CRDs stay strings, and the detail document is its JSON text.
+
+// avroBlockSize is how many records go in each container block
+const avroBlockSize = 1000
+
+var avroMagic = []byte{'O', 'b', 'j', 1}
+
+// avroSchema returns the Avro record schema for a struct type. A record
+// type may only be defined once per schema, so later uses (e.g. the
+// previous employments after the current ones) refer to it by name;
+// defined tracks which have been written.
+func avroSchema(t reflect.Type, defined map[string]bool) any {
+	name := strings.TrimSuffix(t.Name(), "Source")
+	if defined[name] {
+		return "brokercheck." + name
+	}
+	defined[name] = true
+
+	var fields []map[string]any
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+		field := map[string]any{"name": name, "type": avroType(f.Type, defined)}
+		if doc := f.Tag.Get("desc"); doc != "" {
+			field["doc"] = doc
+		}
+		fields = append(fields, field)
+	}
+	return map[string]any{
+		"type":      "record",
+		"name":      name,
+		"namespace": "brokercheck",
+		"fields":    fields,
+	}
+}
+
+// avroType names the Avro type a Go field is written as
+func avroType(t reflect.Type, defined map[string]bool) any {
+	if t == reflect.TypeOf(json.RawMessage{}) {
+		return "string"
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int64:
+		return "long"
+	case reflect.Float64:
+		return "double"
+	case reflect.Slice:
+		return map[string]any{"type": "array", "items": avroType(t.Elem(), defined)}
+	case reflect.Struct:
+		return avroSchema(t, defined)
+	}
+	panic("avro: unsupported field type " + t.String())
+}
+
+// avroEncode appends the Avro binary encoding of v, which must match
+// avroType(v.Type())
+func avroEncode(buf *bytes.Buffer, v reflect.Value) {
+	if v.Type() == reflect.TypeOf(json.RawMessage{}) {
+		avroBytes(buf, v.Bytes())
+		return
+	}
+	switch v.Kind() {
+	case reflect.String:
+		avroBytes(buf, []byte(v.String()))
+	case reflect.Bool:
+		if v.Bool() {
+			buf.WriteByte(1)
+		} else {
+			buf.WriteByte(0)
+		}
+	case reflect.Int, reflect.Int64:
+		avroLong(buf, v.Int())
+	case reflect.Float64:
+		buf.Write(binary.LittleEndian.AppendUint64(nil, math.Float64bits(v.Float())))
+	case reflect.Slice:
+		// One block with every item, then the empty block that ends the array
+		if v.Len() > 0 {
+			avroLong(buf, int64(v.Len()))
+			for i := 0; i < v.Len(); i++ {
+				avroEncode(buf, v.Index(i))
+			}
+		}
+		avroLong(buf, 0)
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+			if name == "" || name == "-" {
+				continue
+			}
+			avroEncode(buf, v.Field(i))
+		}
+	}
+}
+
+// avroLong appends a zigzag varint, Avro's int and long encoding
+func avroLong(buf *bytes.Buffer, n int64) {
+	buf.Write(binary.AppendVarint(nil, n))
+}
+
+// avroBytes appends a length-prefixed byte string
+func avroBytes(buf *bytes.Buffer, b []byte) {
+	avroLong(buf, int64(len(b)))
+	buf.Write(b)
+}
+
+// writeAvro writes brokers to w as an uncompressed Avro container file
+func writeAvro(w io.Writer, brokers []BrokerSource) error {
+	schema, err := json.Marshal(avroSchema(reflect.TypeOf(BrokerSource{}), map[string]bool{}))
+	if err != nil {
+		return err
+	}
+	var sync [16]byte
+	rand.Read(sync[:])
+
+	// Header: magic, the metadata map (one block of two entries), sync
+	var header bytes.Buffer
+	header.Write(avroMagic)
+	avroLong(&header, 2)
+	avroBytes(&header, []byte("avro.schema"))
+	avroBytes(&header, schema)
+	avroBytes(&header, []byte("avro.codec"))
+	avroBytes(&header, []byte("null"))
+	avroLong(&header, 0)
+	header.Write(sync[:])
+	if _, err := w.Write(header.Bytes()); err != nil {
+		return err
+	}
+
+	var block, data bytes.Buffer
+	for start := 0; start < len(brokers); start += avroBlockSize {
+		batch := brokers[start:min(start+avroBlockSize, len(brokers))]
+		data.Reset()
+		for i := range batch {
+			avroEncode(&data, reflect.ValueOf(batch[i]))
+		}
+		block.Reset()
+		avroLong(&block, int64(len(batch)))
+		avroLong(&block, int64(data.Len()))
+		block.Write(data.Bytes())
+		block.Write(sync[:])
+		if _, err := w.Write(block.Bytes()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// saveToAvro writes brokers as an Avro container file
+func saveToAvro(data []BrokerSource, filename string) (int, error) {
+	out, err := createOutput(filename)
+	if err != nil {
+		logErrorf("Error creating Avro file: %v", err)
+		return 0, err
+	}
+	defer out.Close()
+	if err := writeAvro(out, data); err != nil {
+		logErrorf("Error writing Avro file: %v", err)
+		return 0, err
+	}
+	if err := out.Commit(); err != nil {
+		logErrorf("Error writing Avro file: %v", err)
+		return 0, err
+	}
+	log.Printf("Successfully saved %d records to %s", len(data), describeOutput(filename))
+	return len(data), nil
+}
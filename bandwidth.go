@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Bandwidth Limiting
+// -max-bandwidth caps bytes downloaded per second across every request,
+// independently of request pacing, by metering response body reads.
+
+// byteMeter counts the bytes downloaded and, with a positive rate, spaces
+// reads so the long-run average stays at or under rate bytes per second
+type byteMeter struct {
+	rate    int64 // Bytes per second; 0 means unlimited
+	started time.Time
+	total   atomic.Int64
+
+	mu   sync.Mutex
+	next time.Time // When the bytes read so far are "paid for"
+}
+
+func newByteMeter(rate int64) *byteMeter {
+	return &byteMeter{rate: rate, started: time.Now()}
+}
+
+// wait accounts for n bytes just read, sleeping if they put us ahead of
+// the allowed rate
+func (m *byteMeter) wait(ctx context.Context, n int) error {
+	m.total.Add(int64(n))
+	if m.rate <= 0 || n == 0 {
+		return nil
+	}
+	m.mu.Lock()
+	now := time.Now()
+	if m.next.Before(now) {
+		m.next = now
+	}
+	m.next = m.next.Add(time.Duration(float64(n) / float64(m.rate) * float64(time.Second)))
+	until := m.next
+	m.mu.Unlock()
+	return sleepCtx(ctx, time.Until(until))
+}
+
+// Summary describes the bytes downloaded and the average rate since the
+// meter was created
+func (m *byteMeter) Summary() string {
+	elapsed := time.Since(m.started)
+	total := m.total.Load()
+	return fmt.Sprintf("downloaded %s in %s (average %s/s)", formatBytes(total),
+		elapsed.Round(time.Second), formatBytes(int64(float64(total)/max(elapsed.Seconds(), 0.001))))
+}
+
+// meteredReader passes reads through a byteMeter. Reads are capped at a
+// tenth of a second's worth of bytes so throttling stays smooth.
+type meteredReader struct {
+	ctx   context.Context
+	r     io.Reader
+	meter *byteMeter
+}
+
+func (mr *meteredReader) Read(p []byte) (int, error) {
+	if chunk := int(mr.meter.rate / 10); chunk > 0 && len(p) > chunk {
+		p = p[:chunk]
+	}
+	n, err := mr.r.Read(p)
+	if werr := mr.meter.wait(mr.ctx, n); werr != nil && err == nil {
+		err = werr
+	}
+	return n, err
+}
+
+// formatBytes renders a byte count with a binary unit, e.g. "1.5 MiB"
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for v := n / unit; v >= unit; v /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Per-state Output
+// -group-output-by-state writes the -format outputs once per state into a
+// directory (VA.csv, MD.csv, ...), each holding only that state's brokers.
+
+// unknownState is the partition for brokers without a usable state
+const unknownState = "unknown"
+
+// stateKey matches the state codes we accept as file names
+var stateKey = regexp.MustCompile(`^[A-Z]{2}$`)
+
+// partitionByState groups brokers by their first current employment's
+// state. Brokers with no employment or an odd state go under unknownState.
+func partitionByState(brokers []BrokerSource) map[string][]BrokerSource {
+	groups := make(map[string][]BrokerSource)
+	for _, b := range brokers {
+		key := unknownState
+		if len(b.CurrentEmployments) > 0 {
+			if st := strings.ToUpper(strings.TrimSpace(b.CurrentEmployments[0].State)); stateKey.MatchString(st) {
+				key = st
+			}
+		}
+		groups[key] = append(groups[key], b)
+	}
+	return groups
+}
+
+// saveByState writes every -format for each state's brokers into
+// opts.GroupByStateDir
+func saveByState(brokers []BrokerSource, opts *options, search string) outputFiles {
+	dir := opts.GroupByStateDir
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		logErrorf("Error creating -group-output-by-state directory: %v", err)
+		return nil
+	}
+
+	groups := partitionByState(brokers)
+	states := make([]string, 0, len(groups))
+	for st := range groups {
+		states = append(states, st)
+	}
+	sort.Strings(states)
+
+	var written outputFiles
+	for _, st := range states {
+		path := func(format string, count int) string {
+			if name, ok := formatFiles[format]; ok {
+				return filepath.Join(dir, st+"."+name)
+			}
+			return filepath.Join(dir, st+"."+format)
+		}
+		written = append(written, saveFormats(groups[st], opts, search+" ("+st+")", path)...)
+	}
+	log.Printf("Grouped output: %d brokers across %d state files in %s", len(brokers), len(states), dir)
+	return written
+}
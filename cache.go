@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+)
+
+// Response Cache
+// -cache-ttl keeps successful API responses on disk, keyed by the full
+// request URL, and answers a repeated request from there while it is
+// younger than the TTL. Re-running the same search during development
+// then costs FINRA nothing. Entries use the -record-dir file format.
+
+// defaultCacheDir is where cached responses live unless -cache-dir says
+// otherwise
+const defaultCacheDir = ".brokercheck-cache"
+
+// cachingTransport answers fresh cached requests from dir and sends the
+// rest to next, caching any 200 response
+type cachingTransport struct {
+	dir  string
+	ttl  time.Duration
+	next http.RoundTripper
+}
+
+// fresh reports whether url has a cache entry younger than the TTL
+func (t *cachingTransport) fresh(url string) bool {
+	info, err := os.Stat(recordingPath(t.dir, url))
+	return err == nil && time.Since(info.ModTime()) < t.ttl
+}
+
+// exempt lets cache hits skip rate limiting, since they never reach the
+// server (see getJSON)
+func (t *cachingTransport) exempt(req *http.Request) bool {
+	return t.fresh(req.URL.String())
+}
+
+func (t *cachingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	url := req.URL.String()
+	path := recordingPath(t.dir, url)
+	if t.fresh(url) {
+		resp, err := readExchange(path, req)
+		if err == nil {
+			return resp, nil
+		}
+		logErrorf("Ignoring unreadable cache entry for %s: %v", url, err)
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		return resp, err
+	}
+	rec, err := captureExchange(req, resp)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeExchange(path, rec); err != nil {
+		logErrorf("Error caching response for %s: %v", url, err)
+	}
+	return resp, nil
+}
+
+// rateLimitExempt is implemented by transports that can answer some
+// requests without the network, so those needn't wait on the limiter
+type rateLimitExempt interface {
+	exempt(req *http.Request) bool
+}
+
+// setupCache wraps the scraper's transport with a response cache in dir
+// when ttl is positive
+func setupCache(scraper *Scraper, dir string, ttl time.Duration) error {
+	if ttl <= 0 {
+		return nil
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("creating cache directory: %w", err)
+	}
+	next := scraper.Client.Transport
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	scraper.Client.Transport = &cachingTransport{dir: dir, ttl: ttl, next: next}
+	return nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"log"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Output Charset
+// -output-charset ascii rewrites every string field we output as plain
+// ASCII for downstream systems that reject anything else: characters are
+// decomposed (NFKD) and their accents dropped, so "José Müller" becomes
+// "Jose Muller", and the few letters that don't decompose are spelled out
+// (ß → ss, Ø → O). ascii-strip simply drops every non-ASCII character.
+// The -detail document and -raw JSON are left as the API sent them.
+
+// Values accepted by -output-charset
+const (
+	charsetUTF8       = "utf-8"
+	charsetASCII      = "ascii"
+	charsetASCIIStrip = "ascii-strip"
+)
+
+// asciiFallbacks spells out the characters NFKD leaves non-ASCII. Anything
+// else still outside ASCII after decomposition is dropped.
+var asciiFallbacks = map[rune]string{
+	'ß': "ss", 'Æ': "AE", 'æ': "ae", 'Œ': "OE", 'œ': "oe",
+	'Ø': "O", 'ø': "o", 'Đ': "D", 'đ': "d", 'Ð': "D", 'ð': "d",
+	'Ł': "L", 'ł': "l", 'Þ': "TH", 'þ': "th", 'ı': "i",
+	'‘': "'", '’': "'", '‚': "'", '“': `"`, '”': `"`, '„': `"`,
+	'–': "-", '—': "-", '…': "...", '•': "*",
+}
+
+// toASCII converts s for charset, returning s unchanged when it is already
+// ASCII
+func toASCII(s, charset string) string {
+	ascii := true
+	for i := 0; i < len(s); i++ {
+		if s[i] > unicode.MaxASCII {
+			ascii = false
+			break
+		}
+	}
+	if ascii {
+		return s
+	}
+	if charset == charsetASCII {
+		s = norm.NFKD.String(s)
+	}
+	var sb strings.Builder
+	for _, r := range s {
+		switch {
+		case r <= unicode.MaxASCII:
+			sb.WriteRune(r)
+		case charset == charsetASCII && !unicode.Is(unicode.Mn, r):
+			sb.WriteString(asciiFallbacks[r])
+		}
+	}
+	return sb.String()
+}
+
+// applyCharset converts brokers to -output-charset, if it isn't UTF-8. It
+// runs after postProcess and -detail, so the exams are converted too.
+func applyCharset(brokers []BrokerSource, opts *options, logger *log.Logger) {
+	if opts.OutputCharset == charsetUTF8 {
+		return
+	}
+	n := convertCharset(brokers, opts.OutputCharset)
+	logger.Printf("Output charset %s: rewrote %d fields", opts.OutputCharset, n)
+}
+
+// convertCharset rewrites every string field we output with toASCII and
+// returns how many fields changed
+func convertCharset(brokers []BrokerSource, charset string) int {
+	changed := 0
+	conv := func(s *string) {
+		if a := toASCII(*s, charset); a != *s {
+			*s = a
+			changed++
+		}
+	}
+	for i := range brokers {
+		b := &brokers[i]
+		conv(&b.FirstName)
+		conv(&b.LastName)
+		conv(&b.IndustryStartDate)
+		conv(&b.Source)
+		conv(&b.WatchlistMatch)
+		for j := range b.Exams {
+			conv(&b.Exams[j])
+		}
+		for _, emps := range [][]Employment{b.CurrentEmployments, b.PreviousEmployments} {
+			for j := range emps {
+				e := &emps[j]
+				conv(&e.FirmName)
+				conv(&e.City)
+				conv(&e.State)
+				conv(&e.StateName)
+				conv(&e.Zip)
+				conv(&e.County)
+			}
+		}
+	}
+	return changed
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+// Health Check
+// `brokercheck-scraper check` makes one minimal search request and reports
+// whether the API is reachable and still answers in the shape we parse.
+// It exits 0 on success and 1 otherwise, for use as a cron pre-flight.
+
+// runCheck implements the check subcommand and returns the exit code
+func runCheck(args []string) int {
+	fs := flag.NewFlagSet("check", flag.ExitOnError)
+	region := fs.String("region", "", "Named search preset to check against (default: the built-in D.C. search)")
+	timeout := fs.Duration("timeout", 15*time.Second, "Give up on the request after this long")
+	strict := fs.Bool("strict-schema", false, "Also fail if the hit has _source keys the scraper doesn't model")
+	fs.Parse(args)
+	if err := applyEnv(fs); err != nil {
+		log.Printf("check: invalid environment setting: %v", err)
+		return 1
+	}
+
+	regions, err := parseRegionList(*region)
+	if err != nil {
+		log.Printf("check: invalid -region: %v", err)
+		return 1
+	}
+	if len(regions) != 1 {
+		log.Printf("check: -region must name a single preset")
+		return 1
+	}
+	r := regions[0]
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	scraper := NewScraper(Config{Latitude: r.Lat, Longitude: r.Lon, Radius: r.Radius, PageTimeout: *timeout, Referer: siteURL + "/", Origin: siteURL, StrictSchema: *strict})
+
+	start := time.Now()
+	total, crd, err := checkAPI(ctx, scraper)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "FAIL: %v\n", err)
+		return 1
+	}
+	elapsed := time.Since(start).Round(time.Millisecond)
+	if total == 0 {
+		fmt.Printf("OK: API answered in %s, but with 0 results for %s, so the hit fields weren't checked\n", elapsed, r.label())
+		return 0
+	}
+	fmt.Printf("OK: API answered in %s; %d results for %s (first CRD %s)\n", elapsed, total, r.label(), crd)
+	return 0
+}
+
+// checkAPI fetches one result and verifies the response has the fields
+// Fetch relies on. It returns the reported total and the first hit's CRD.
+func checkAPI(ctx context.Context, s *Scraper) (int, string, error) {
+	var shape struct {
+		Hits *struct {
+			Total *int `json:"total"`
+			Hits  []struct {
+				Source *BrokerSource `json:"_source"`
+			} `json:"hits"`
+		} `json:"hits"`
+	}
+	body, err := s.getBody(ctx, s.Config.APIURL, s.searchQuery(0, 1))
+	if err != nil {
+		return 0, "", fmt.Errorf("request failed: %w", err)
+	}
+	if err := json.Unmarshal(body, &shape); err != nil {
+		return 0, "", fmt.Errorf("unexpected response shape: %w", err)
+	}
+	switch {
+	case shape.Hits == nil:
+		return 0, "", errors.New(`unexpected response shape: no "hits" object`)
+	case shape.Hits.Total == nil:
+		return 0, "", errors.New(`unexpected response shape: no "hits.total"`)
+	case *shape.Hits.Total == 0:
+		return 0, "", nil
+	case len(shape.Hits.Hits) == 0 || shape.Hits.Hits[0].Source == nil:
+		return 0, "", fmt.Errorf(`unexpected response shape: %d results reported but no "_source" in the hits`, *shape.Hits.Total)
+	case shape.Hits.Hits[0].Source.CRD == "":
+		return 0, "", errors.New(`unexpected response shape: first hit has no "ind_source_id"; the API fields may have been renamed`)
+	}
+	if s.Config.StrictSchema {
+		if _, err := s.parseResponse(body); err != nil {
+			return 0, "", fmt.Errorf("schema drift: %w", err)
+		}
+	}
+	return *shape.Hits.Total, shape.Hits.Hits[0].Source.CRD, nil
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+// Checkpoints
+// -checkpoint-every N saves a search's progress (pages fetched, the offset
+// of the next page and every broker so far) to checkpoint.json every N
+// pages and again if the scrape stops early, so a run over a dense area
+// that fails or is interrupted can carry on with -resume instead of
+// starting over. The file is replaced atomically and removed once a scrape
+// completes.
+
+// checkpointFile is where progress is saved and -resume looks for it
+const checkpointFile = "checkpoint.json"
+
+// checkpoint is the contents of checkpointFile
+type checkpoint struct {
+	Search  checkpointSearch `json:"search"`
+	Page    int              `json:"page"`   // Pages fetched so far
+	Offset  int              `json:"offset"` // Record the next page starts at
+	Total   int              `json:"total"`  // As last reported by the API
+	SavedAt string           `json:"saved_at"`
+	Brokers []BrokerSource   `json:"brokers"`
+}
+
+// checkpointSearch identifies the search a checkpoint belongs to, so one
+// isn't resumed into a different search
+type checkpointSearch struct {
+	Lat    string   `json:"lat,omitempty"`
+	Lon    string   `json:"lon,omitempty"`
+	Radius string   `json:"radius,omitempty"`
+	Query  string   `json:"query,omitempty"`
+	States []string `json:"states,omitempty"`
+	Sort   string   `json:"sort,omitempty"`
+}
+
+func searchOf(cfg Config) checkpointSearch {
+	return checkpointSearch{cfg.Latitude, cfg.Longitude, cfg.Radius, cfg.Query, cfg.States, cfg.Sort}
+}
+
+func (c checkpointSearch) String() string {
+	if c.Lat == "" {
+		return fmt.Sprintf("query %q", c.Query)
+	}
+	s := fmt.Sprintf("%s,%s within %s miles", c.Lat, c.Lon, c.Radius)
+	if c.Query != "" {
+		s = fmt.Sprintf("query %q, %s", c.Query, s)
+	}
+	return s
+}
+
+func (c checkpointSearch) equal(o checkpointSearch) bool {
+	return c.Lat == o.Lat && c.Lon == o.Lon && c.Radius == o.Radius && c.Query == o.Query &&
+		c.Sort == o.Sort && fmt.Sprint(c.States) == fmt.Sprint(o.States)
+}
+
+// saveCheckpoint replaces filename with cp
+func saveCheckpoint(filename string, cp checkpoint) error {
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filename, data)
+}
+
+// loadCheckpoint reads filename, or returns nil if there isn't one
+func loadCheckpoint(filename string) (*checkpoint, error) {
+	data, err := os.ReadFile(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var cp checkpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	return &cp, nil
+}
+
+// scrapeCheckpointed runs s like collectPages, resuming from checkpointFile
+// with -resume and saving progress to it with -checkpoint-every
+func scrapeCheckpointed(ctx context.Context, s *Scraper, opts *options) ([]BrokerSource, error) {
+	var all []BrokerSource
+	search := searchOf(s.Config)
+	if opts.Resume {
+		cp, err := loadCheckpoint(checkpointFile)
+		switch {
+		case err != nil:
+			return nil, fmt.Errorf("-resume: %w", err)
+		case cp == nil:
+			log.Printf("Resume: no %s; starting from the first page", checkpointFile)
+		case !cp.Search.equal(search):
+			return nil, fmt.Errorf("-resume: %s is for a different search (%s); delete it or repeat that search's flags", checkpointFile, cp.Search)
+		default:
+			all = cp.Brokers
+			s.Config.StartPage, s.Config.StartOffset = cp.Page, cp.Offset
+			log.Printf("Resume: loaded %d brokers from %s (saved %s); continuing at page %d, record %d of %d",
+				len(all), checkpointFile, cp.SavedAt, cp.Page+1, cp.Offset, cp.Total)
+		}
+	}
+
+	var pw *pageWriter
+	if opts.PerPageDir != "" {
+		var err error
+		if pw, err = newPageWriter(opts.PerPageDir, ""); err != nil {
+			return nil, err
+		}
+		pw.pages = s.Config.StartPage
+	}
+	page, offset := s.Config.StartPage, s.Config.StartOffset
+	saved := page
+	save := func() {
+		if opts.CheckpointEvery == 0 || page == saved {
+			return
+		}
+		cp := checkpoint{Search: search, Page: page, Offset: offset, Total: s.Stats.Reported, SavedAt: time.Now().UTC().Format(time.RFC3339), Brokers: all}
+		if err := saveCheckpoint(checkpointFile, cp); err != nil {
+			logErrorf("Error writing %s: %v", checkpointFile, err)
+			return
+		}
+		saved = page
+		log.Printf("Checkpoint: saved %d brokers through page %d to %s", len(all), page, checkpointFile)
+	}
+
+	err := s.Stream(ctx, func(p []BrokerSource) error {
+		if pw != nil {
+			if err := pw.Write(p); err != nil {
+				return fmt.Errorf("writing page %d: %w", pw.pages, err)
+			}
+		}
+		all = append(all, p...)
+		page++
+		offset += len(p)
+		if opts.CheckpointEvery > 0 && (page-s.Config.StartPage)%opts.CheckpointEvery == 0 {
+			save()
+		}
+		return nil
+	})
+	if err != nil || ctx.Err() != nil {
+		save()
+	}
+	return all, err
+}
+
+// removeCheckpoint deletes checkpointFile after a scrape that completed
+func removeCheckpoint() {
+	err := os.Remove(checkpointFile)
+	switch {
+	case err == nil:
+		log.Printf("Checkpoint: scrape complete; removed %s", checkpointFile)
+	case !errors.Is(err, os.ErrNotExist):
+		logErrorf("Error removing %s: %v", checkpointFile, err)
+	}
+}
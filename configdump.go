@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"io"
+	"sort"
+)
+
+// Config Dump
+// -config-dump prints the configuration a run would use and exits, to
+// debug which of the command line, the BROKERCHECK_* environment and the
+// defaults set each flag. Secrets are redacted.
+
+// secretFlags are the flags whose values are never printed or saved
+var secretFlags = map[string]bool{"dsn": true}
+
+// redactFlag hides the value of a secret flag
+func redactFlag(name, value string) string {
+	if secretFlags[name] && value != "" {
+		return "(redacted)"
+	}
+	return value
+}
+
+// Where a flag's value came from
+const (
+	sourceFlag    = "flag"
+	sourceEnv     = "env"
+	sourceDefault = "default"
+)
+
+type configFlag struct {
+	Value  string `json:"value"`
+	Source string `json:"source"`
+	Env    string `json:"env,omitempty"` // The variable, when Source is env
+}
+
+type configRegion struct {
+	Name   string `json:"name,omitempty"`
+	Lat    string `json:"lat"`
+	Lon    string `json:"lon"`
+	Radius string `json:"radius_miles"`
+}
+
+type configResolved struct {
+	Regions        []configRegion `json:"regions"`
+	Formats        []string       `json:"formats"`
+	MinRadiusMiles float64        `json:"min_radius_miles"`
+	Seed           uint64         `json:"seed"`
+}
+
+type configDump struct {
+	Flags    map[string]configFlag `json:"flags"`
+	Resolved configResolved        `json:"resolved"`
+}
+
+// dumpConfig writes every flag's effective value and source, plus the
+// values resolve derived from them, as indented JSON
+func dumpConfig(w io.Writer, o *options) error {
+	dump := configDump{Flags: make(map[string]configFlag)}
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	flag.VisitAll(func(f *flag.Flag) {
+		entry := configFlag{Value: redactFlag(f.Name, f.Value.String()), Source: sourceDefault}
+		switch {
+		case o.cliFlags[f.Name]:
+			entry.Source = sourceFlag
+		case set[f.Name]:
+			entry.Source, entry.Env = sourceEnv, envName(f.Name)
+		}
+		dump.Flags[f.Name] = entry
+	})
+
+	for _, r := range o.regions {
+		dump.Resolved.Regions = append(dump.Resolved.Regions, configRegion{Name: r.Name, Lat: r.Lat, Lon: r.Lon, Radius: r.Radius})
+	}
+	for format := range o.formats {
+		dump.Resolved.Formats = append(dump.Resolved.Formats, format)
+	}
+	sort.Strings(dump.Resolved.Formats)
+	dump.Resolved.MinRadiusMiles = o.MinRadius
+	dump.Resolved.Seed = o.Seed
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(dump)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"log"
+	"strconv"
+)
+
+// countByState runs a count-only search around the center of every state
+// (using the scraper's configured radius) and writes state,count rows to
+// filename. A state whose request fails is logged and written with an
+// empty count so the gap is visible.
+func countByState(ctx context.Context, scraper *Scraper, filename string) {
+	file, err := createOutput(filename)
+	if err != nil {
+		logErrorf("Error creating CSV file: %v", err)
+		return
+	}
+	defer file.Close()
+
+	writer := csv.NewWriter(file)
+	defer writer.Flush()
+	writer.Write([]string{"state", "count"})
+
+	for _, st := range usStates {
+		total, err := scraper.TotalAt(ctx, st.Lat, st.Lon, scraper.Config.Radius)
+		if err != nil {
+			if ctx.Err() != nil {
+				logErrorf("Count by state interrupted: %v", ctx.Err())
+				return
+			}
+			logErrorf("Error counting %s: %v", st.Code, err)
+			writer.Write([]string{st.Code, ""})
+			continue
+		}
+		log.Printf("%s: %d brokers within %s miles of the state center", st.Code, total, scraper.Config.Radius)
+		writer.Write([]string{st.Code, strconv.Itoa(total)})
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		logErrorf("Error writing CSV file: %v", err)
+		return
+	}
+	if err := file.Commit(); err != nil {
+		logErrorf("Error writing CSV file: %v", err)
+		return
+	}
+	log.Printf("Successfully saved to %s", filename)
+}
+
+// checkStateFilter compares the search total with and without the
+// server-side state filter. If the totals match, the server most likely
+// ignored the filter and a warning is logged; the client-side filter still
+// applies either way.
+func checkStateFilter(ctx context.Context, scraper *Scraper) {
+	unfiltered := *scraper
+	unfiltered.Config.States = nil
+
+	without, err := unfiltered.Total(ctx)
+	if err != nil {
+		logErrorf("Error checking the state filter: %v", err)
+		return
+	}
+	with, err := scraper.Total(ctx)
+	if err != nil {
+		logErrorf("Error checking the state filter: %v", err)
+		return
+	}
+
+	if with == without {
+		log.Printf("Warning: the server-side state filter had no effect (%d results either way); filtering client-side instead", with)
+		return
+	}
+	log.Printf("Server-side state filter applied: %d of %d results", with, without)
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// County Enrichment
+// -zip-county adds the county name and 5-digit county FIPS code of each
+// branch ZIP to its employment, saving a join in GIS work. The table
+// built into the binary only covers the default D.C. search;
+// -zip-county-file adds rows from a fuller table, such as HUD's USPS
+// ZIP-to-county crosswalk or the Census ZCTA-to-county relationship file
+// reduced to zip,county,fips columns. A ZIP in several counties should be
+// listed once, under the county holding most of it.
+
+//go:embed zipcounty.csv
+var builtinZipCounties string
+
+// fipsPattern matches a county FIPS code, allowing for a dropped leading
+// zero
+var fipsPattern = regexp.MustCompile(`^[0-9]{4,5}$`)
+
+// countyInfo is a ZIP's county
+type countyInfo struct {
+	Name string
+	FIPS string
+}
+
+// parseZipCounties reads zip,county,fips rows. A header row (one whose
+// FIPS isn't a number) and lines starting with # are skipped.
+func parseZipCounties(r io.Reader, name string, counties map[string]countyInfo) error {
+	reader := csv.NewReader(r)
+	reader.Comment = '#'
+	reader.FieldsPerRecord = 3
+	reader.TrimLeadingSpace = true
+
+	for first := true; ; first = false {
+		row, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		fips := strings.TrimSpace(row[2])
+		if !fipsPattern.MatchString(fips) {
+			if first {
+				continue // Header
+			}
+			line, _ := reader.FieldPos(2)
+			return fmt.Errorf("%s line %d: invalid FIPS code %q", name, line, row[2])
+		}
+		counties[normalizeZip(row[0], false)] = countyInfo{
+			Name: strings.TrimSpace(row[1]),
+			FIPS: fmt.Sprintf("%05s", fips),
+		}
+	}
+}
+
+// loadZipCounties returns the built-in table with the rows of filename,
+// if given, added over it
+func loadZipCounties(filename string) (map[string]countyInfo, error) {
+	counties := make(map[string]countyInfo)
+	if err := parseZipCounties(strings.NewReader(builtinZipCounties), "built-in table", counties); err != nil {
+		return nil, err
+	}
+	if filename == "" {
+		return counties, nil
+	}
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	if err := parseZipCounties(file, filename, counties); err != nil {
+		return nil, err
+	}
+	return counties, nil
+}
+
+// addCounties fills in County and FIPS on every employment whose ZIP is in
+// counties, leaving the rest empty, and returns how many were found and
+// looked up
+func addCounties(brokers []BrokerSource, counties map[string]countyInfo) (found, total int) {
+	fill := func(emps []Employment) {
+		for j := range emps {
+			e := &emps[j]
+			e.County, e.FIPS = "", ""
+			total++
+			if c, ok := counties[normalizeZip(e.Zip, false)]; ok {
+				e.County, e.FIPS = c.Name, c.FIPS
+				found++
+			}
+		}
+	}
+	for i := range brokers {
+		fill(brokers[i].CurrentEmployments)
+		fill(brokers[i].PreviousEmployments)
+	}
+	return found, total
+}
+
+// countyCoverage counts the employments of brokers and how many of them
+// addCounties found a county for
+func countyCoverage(brokers []BrokerSource) (found, total int) {
+	for i := range brokers {
+		for _, emps := range [][]Employment{brokers[i].CurrentEmployments, brokers[i].PreviousEmployments} {
+			for j := range emps {
+				total++
+				if emps[j].FIPS != "" {
+					found++
+				}
+			}
+		}
+	}
+	return found, total
+}
+
+// warnCountyMisses warns when fewer than half of the branch ZIPs were in
+// the county table, as happens with only the built-in rows anywhere but
+// D.C.
+func warnCountyMisses(found, total int, file string) {
+	if total == 0 || found*2 >= total {
+		return
+	}
+	hint := "the built-in table only covers the D.C. area; add a national zip,county,fips table with -zip-county-file"
+	if file != "" {
+		hint = "check that " + file + " covers the searched area"
+	}
+	log.Printf("Warning: only %d of %d branch ZIPs were in the county table, so most employments have no county; %s", found, total, hint)
+}
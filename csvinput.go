@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// CSV Input
+// brokers.csv can be read back like brokers.json, e.g. by -input, merge
+// and diff. Columns are found by their header names, so -csv-sort-columns
+// and the optional columns don't matter, and the delimiter is sniffed from
+// the header row. The default layout only holds each broker's first
+// current employment; a -flatten file's consecutive rows for one CRD are
+// joined back into a single broker with all its employments. The CSV has
+// no industry start date, so YearsExperience is kept as written.
+
+// csvBrokerReader reads brokers from a CSV written by saveToCSV, one at a
+// time
+type csvBrokerReader struct {
+	r       *csv.Reader
+	col     map[string]int
+	flat    bool     // One row per employment, with an IsCurrent column
+	pending []string // A row read ahead while joining a flattened broker
+}
+
+// newCSVBrokerReader reads the header row of r
+func newCSVBrokerReader(r *bufio.Reader) (*csvBrokerReader, error) {
+	reader := csv.NewReader(r)
+	reader.Comma = sniffDelimiter(r)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("reading CSV header: %w", err)
+	}
+	cr := &csvBrokerReader{r: reader, col: make(map[string]int)}
+	for i, name := range header {
+		cr.col[strings.TrimSpace(strings.TrimPrefix(name, "\ufeff"))] = i
+	}
+	if _, ok := cr.col["CRD"]; !ok {
+		return nil, fmt.Errorf("CSV header has no CRD column")
+	}
+	_, cr.flat = cr.col["IsCurrent"]
+	return cr, nil
+}
+
+// sniffDelimiter guesses the delimiter from the header row: whichever of
+// the ones -delimiter accepts it holds most of
+func sniffDelimiter(r *bufio.Reader) rune {
+	line, _ := r.Peek(r.Size())
+	if i := strings.IndexByte(string(line), '\n'); i >= 0 {
+		line = line[:i]
+	}
+	best, count := ',', strings.Count(string(line), ",")
+	for _, d := range []rune{';', '\t'} {
+		if n := strings.Count(string(line), string(d)); n > count {
+			best, count = d, n
+		}
+	}
+	return best
+}
+
+// field returns the named column of row, or "" without one
+func (cr *csvBrokerReader) field(row []string, name string) string {
+	if i, ok := cr.col[name]; ok && i < len(row) {
+		return row[i]
+	}
+	return ""
+}
+
+// Read returns the next broker, or io.EOF after the last
+func (cr *csvBrokerReader) Read() (BrokerSource, error) {
+	row := cr.pending
+	cr.pending = nil
+	if row == nil {
+		var err error
+		if row, err = cr.r.Read(); err != nil {
+			return BrokerSource{}, err
+		}
+	}
+	b := cr.broker(row)
+	cr.addEmployment(&b, row)
+	if !cr.flat {
+		return b, nil
+	}
+	for {
+		next, err := cr.r.Read()
+		if err == io.EOF {
+			return b, nil
+		}
+		if err != nil {
+			return BrokerSource{}, err
+		}
+		if cr.field(next, "CRD") != b.CRD {
+			cr.pending = next
+			return b, nil
+		}
+		cr.addEmployment(&b, next)
+	}
+}
+
+// broker fills in the broker-level columns of row
+func (cr *csvBrokerReader) broker(row []string) BrokerSource {
+	b := BrokerSource{
+		CRD:            cr.field(row, "CRD"),
+		FirstName:      cr.field(row, "FirstName"),
+		LastName:       cr.field(row, "LastName"),
+		Source:         cr.field(row, "Source"),
+		WatchlistMatch: cr.field(row, "WatchlistMatch"),
+	}
+	b.Index, _ = strconv.Atoi(cr.field(row, "Index"))
+	b.YearsExperience, _ = strconv.ParseFloat(cr.field(row, "YearsExperience"), 64)
+	b.WatchlistScore, _ = strconv.ParseFloat(cr.field(row, "WatchlistScore"), 64)
+	if exams := cr.field(row, "Exams"); exams != "" {
+		b.Exams = strings.Split(exams, ";")
+	}
+	return b
+}
+
+// addEmployment appends the employment in row, if it has one, to b's
+// current or previous employments
+func (cr *csvBrokerReader) addEmployment(b *BrokerSource, row []string) {
+	e := Employment{
+		FirmCRD:   cr.field(row, "FirmCRD"),
+		FirmName:  cr.field(row, "FirmName"),
+		City:      cr.field(row, "FirmCity"),
+		State:     cr.field(row, "FirmState"),
+		StateName: cr.field(row, "FirmStateName"),
+		Zip:       cr.field(row, "FirmZip"),
+		County:    cr.field(row, "FirmCounty"),
+		FIPS:      cr.field(row, "FirmFIPS"),
+		BeginDate: cr.field(row, "RegistrationBegin"),
+		EndDate:   cr.field(row, "RegistrationEnd"),
+	}
+	if e == (Employment{}) {
+		return
+	}
+	if cr.flat && cr.field(row, "IsCurrent") == "false" {
+		b.PreviousEmployments = append(b.PreviousEmployments, e)
+	} else {
+		b.CurrentEmployments = append(b.CurrentEmployments, e)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/csv"
+	"log"
+	"sort"
+	"strconv"
+)
+
+// Dedupe Report
+// -dedupe-report lists every CRD the scrape returned more than once and
+// how often, to help explain why pages overlap (e.g. the API reordering
+// results between requests).
+
+// crdCount is one row of dedupe-report.csv
+type crdCount struct {
+	CRD         string
+	Occurrences int
+}
+
+// duplicateCRDs counts the CRDs seen more than once, most repeated first
+// (ties by CRD). Brokers without a CRD are ignored. It must run before
+// dedupe, which reuses the slice.
+func duplicateCRDs(brokers []BrokerSource) []crdCount {
+	counts := make(map[string]int)
+	for _, b := range brokers {
+		if b.CRD != "" {
+			counts[b.CRD]++
+		}
+	}
+	var dups []crdCount
+	for crd, n := range counts {
+		if n > 1 {
+			dups = append(dups, crdCount{crd, n})
+		}
+	}
+	sort.Slice(dups, func(i, j int) bool {
+		if dups[i].Occurrences != dups[j].Occurrences {
+			return dups[i].Occurrences > dups[j].Occurrences
+		}
+		return dups[i].CRD < dups[j].CRD
+	})
+	return dups
+}
+
+// saveDedupeReport writes the repeated CRDs as crd,occurrences rows
+func saveDedupeReport(dups []crdCount, filename string, comma rune) (int, error) {
+	file, err := createOutput(filename)
+	if err != nil {
+		logErrorf("Error creating dedupe report: %v", err)
+		return 0, err
+	}
+	defer file.Close()
+
+	writer := csv.NewWriter(file)
+	if comma != 0 {
+		writer.Comma = comma
+	}
+	writer.Write([]string{"crd", "occurrences"})
+	for _, d := range dups {
+		writer.Write([]string{d.CRD, strconv.Itoa(d.Occurrences)})
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		logErrorf("Error writing dedupe report: %v", err)
+		return 0, err
+	}
+	if err := file.Commit(); err != nil {
+		logErrorf("Error writing dedupe report: %v", err)
+		return 0, err
+	}
+	log.Printf("Successfully saved %d repeated CRDs to %s", len(dups), filename)
+	return len(dups), nil
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+	"sync"
+)
+
+// Detail Enrichment
+// The search endpoint only returns a summary of each broker. The per-CRD
+// detail endpoint has the full record (exams, registrations, disclosures),
+// which the API hands back as a JSON document encoded inside a string.
+
+// detailResponse matches the envelope returned by the detail endpoint
+type detailResponse struct {
+	Hits struct {
+		Hits []struct {
+			Source struct {
+				Content string `json:"content"`
+			} `json:"_source"`
+		} `json:"hits"`
+	} `json:"hits"`
+}
+
+// detailExams matches the exam lists in a detail document. Each exam has
+// a short code (examCategory, e.g. "Series 7") and a long name.
+type detailExams struct {
+	State     []detailExam `json:"stateExamCategory"`
+	Principal []detailExam `json:"principalExamCategory"`
+	Product   []detailExam `json:"productExamCategory"`
+}
+
+type detailExam struct {
+	Category string `json:"examCategory"`
+	Name     string `json:"examName"`
+}
+
+// parseExams lists the exams a detail document shows as passed, by short
+// code where there is one. A document without exam lists gives none.
+func parseExams(detail json.RawMessage) ([]string, error) {
+	var doc detailExams
+	if err := json.Unmarshal(detail, &doc); err != nil {
+		return nil, err
+	}
+	var exams []string
+	for _, list := range [][]detailExam{doc.Product, doc.State, doc.Principal} {
+		for _, e := range list {
+			name := e.Category
+			if name == "" {
+				name = e.Name
+			}
+			if name != "" && !contains(exams, name) {
+				exams = append(exams, name)
+			}
+		}
+	}
+	return exams, nil
+}
+
+// FetchDetail retrieves the full detail document for one broker
+func (s *Scraper) FetchDetail(ctx context.Context, crd string) (json.RawMessage, error) {
+	q := url.Values{}
+	q.Set("hl", strconv.FormatBool(s.Config.Highlight))
+	q.Set("includePrevious", strconv.FormatBool(!s.Config.OmitPrevious))
+	q.Set("wt", "json")
+
+	var resp detailResponse
+	if err := s.getJSON(ctx, s.Config.APIURL+"/"+url.PathEscape(crd), q, &resp); err != nil {
+		return nil, err
+	}
+	if len(resp.Hits.Hits) == 0 {
+		return nil, fmt.Errorf("no detail document for CRD %s", crd)
+	}
+
+	content := resp.Hits.Hits[0].Source.Content
+	if !json.Valid([]byte(content)) {
+		return nil, fmt.Errorf("detail content for CRD %s is not valid JSON", crd)
+	}
+	return json.RawMessage(content), nil
+}
+
+// EnrichDetails fetches the detail document for every broker, storing it
+// in Detail and its exam list in Exams. Lookups share the Scraper's
+// concurrency semaphore and rate limiter with search paging. A broker whose
+// detail fetch fails is logged and left without a Detail.
+func (s *Scraper) EnrichDetails(ctx context.Context, brokers []BrokerSource) error {
+	workers := s.Config.MaxConcurrent
+	log.Printf("Fetching detail documents for %d brokers (up to %d at once)...", len(brokers), workers)
+
+	var (
+		mu   sync.Mutex
+		done int
+	)
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if sleepCtx(ctx, rampDelay(w, workers, s.Config.Ramp)) != nil {
+				return
+			}
+			for i := range jobs {
+				detail, err := s.FetchDetail(ctx, brokers[i].CRD)
+				retry := 1
+				for ; err != nil && s.retryWait(ctx, retry, err); retry++ {
+					s.reportError(ErrorEvent{Phase: PhaseDetail, CRD: brokers[i].CRD, Err: err})
+					detail, err = s.FetchDetail(ctx, brokers[i].CRD)
+				}
+				if err != nil {
+					err = s.retryFailure(ctx, retry, err)
+					if ctx.Err() == nil {
+						logErrorf("Error fetching detail for CRD %s: %v", brokers[i].CRD, err)
+						s.reportError(ErrorEvent{Phase: PhaseDetail, CRD: brokers[i].CRD, Err: err})
+					}
+				} else {
+					brokers[i].Detail = detail
+					exams, err := parseExams(detail)
+					if err != nil {
+						logErrorf("Error reading exams for CRD %s: %v", brokers[i].CRD, err)
+					}
+					brokers[i].Exams = exams
+				}
+
+				mu.Lock()
+				done++
+				if s.ProgressFunc != nil {
+					s.ProgressFunc(PhaseDetail, done, len(brokers))
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	var err error
+feed:
+	for i := range brokers {
+		select {
+		case jobs <- i:
+		case <-ctx.Done():
+			err = ctx.Err()
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	return err
+}
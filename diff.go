@@ -0,0 +1,215 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Snapshot Diffs
+// `brokercheck-scraper diff old.json new.json` compares two saved scrapes
+// by CRD, offline: brokers added, removed, or whose current firms changed.
+// The report is printed as text, and -json also writes it as JSON.
+
+// diffBroker is a broker in one of the snapshots
+type diffBroker struct {
+	CRD   string   `json:"crd"`
+	Name  string   `json:"name"`
+	Firms []string `json:"firms"`
+}
+
+// firmMove is a broker in both snapshots whose current firms differ
+type firmMove struct {
+	CRD  string   `json:"crd"`
+	Name string   `json:"name"`
+	From []string `json:"from"`
+	To   []string `json:"to"`
+}
+
+// snapshotDiff is the diff subcommand's report
+type snapshotDiff struct {
+	Old      string       `json:"old"`
+	New      string       `json:"new"`
+	OldCount int          `json:"old_count"`
+	NewCount int          `json:"new_count"`
+	Added    []diffBroker `json:"added"`
+	Removed  []diffBroker `json:"removed"`
+	Moved    []firmMove   `json:"moved"`
+}
+
+// currentFirms lists a broker's current firm names, sorted, for comparing
+// snapshots; the API's order of employments isn't meaningful
+func currentFirms(b BrokerSource) []string {
+	firms := []string{}
+	for _, e := range b.CurrentEmployments {
+		if name := collapseSpaces(e.FirmName); name != "" && !slices.Contains(firms, name) {
+			firms = append(firms, name)
+		}
+	}
+	sort.Strings(firms)
+	return firms
+}
+
+// byCRD indexes brokers by CRD, keeping the first of any duplicates.
+// Brokers without a CRD can't be matched and are left out.
+func byCRD(brokers []BrokerSource) map[string]BrokerSource {
+	index := make(map[string]BrokerSource, len(brokers))
+	for _, b := range brokers {
+		if _, ok := index[b.CRD]; !ok && b.CRD != "" {
+			index[b.CRD] = b
+		}
+	}
+	return index
+}
+
+// diffSnapshots compares two scrapes by CRD. Firm moves compare current
+// firm names, case-insensitively with whitespace collapsed. Each list is
+// sorted by CRD.
+func diffSnapshots(old, cur []BrokerSource) snapshotDiff {
+	before, after := byCRD(old), byCRD(cur)
+	d := snapshotDiff{OldCount: len(before), NewCount: len(after), Added: []diffBroker{}, Removed: []diffBroker{}, Moved: []firmMove{}}
+	name := func(b BrokerSource) string {
+		return collapseSpaces(b.FirstName + " " + b.LastName)
+	}
+	for crd, b := range after {
+		prev, ok := before[crd]
+		if !ok {
+			d.Added = append(d.Added, diffBroker{crd, name(b), currentFirms(b)})
+			continue
+		}
+		from, to := currentFirms(prev), currentFirms(b)
+		if !sameFirms(from, to) {
+			d.Moved = append(d.Moved, firmMove{crd, name(b), from, to})
+		}
+	}
+	for crd, b := range before {
+		if _, ok := after[crd]; !ok {
+			d.Removed = append(d.Removed, diffBroker{crd, name(b), currentFirms(b)})
+		}
+	}
+	slices.SortFunc(d.Added, func(a, b diffBroker) int { return compareCRDs(a.CRD, b.CRD) })
+	slices.SortFunc(d.Removed, func(a, b diffBroker) int { return compareCRDs(a.CRD, b.CRD) })
+	slices.SortFunc(d.Moved, func(a, b firmMove) int { return compareCRDs(a.CRD, b.CRD) })
+	return d
+}
+
+// sameFirms reports whether two firm lists hold the same firms by
+// firmNameKey
+func sameFirms(a, b []string) bool {
+	keys := func(firms []string) []string {
+		k := make([]string, len(firms))
+		for i, f := range firms {
+			k[i] = firmNameKey(f)
+		}
+		sort.Strings(k)
+		return slices.Compact(k)
+	}
+	return slices.Equal(keys(a), keys(b))
+}
+
+// compareCRDs orders CRDs by value when both are plain digits, and as
+// text otherwise
+func compareCRDs(a, b string) int {
+	if len(a) != len(b) && crdPattern.MatchString(a) && crdPattern.MatchString(b) {
+		return len(a) - len(b)
+	}
+	return strings.Compare(a, b)
+}
+
+// firmList formats firms for the text report
+func firmList(firms []string) string {
+	if len(firms) == 0 {
+		return "(no current firm)"
+	}
+	return strings.Join(firms, "; ")
+}
+
+// writeDiffText writes the human-readable report
+func writeDiffText(w io.Writer, d snapshotDiff) {
+	fmt.Fprintf(w, "Comparing %s (%d brokers) with %s (%d brokers)\n", d.Old, d.OldCount, d.New, d.NewCount)
+	fmt.Fprintf(w, "Added: %d, removed: %d, moved firm: %d\n", len(d.Added), len(d.Removed), len(d.Moved))
+	if len(d.Added) > 0 {
+		fmt.Fprintln(w, "\nAdded:")
+		for _, b := range d.Added {
+			fmt.Fprintf(w, "  + %s %s: %s\n", b.CRD, b.Name, firmList(b.Firms))
+		}
+	}
+	if len(d.Removed) > 0 {
+		fmt.Fprintln(w, "\nRemoved:")
+		for _, b := range d.Removed {
+			fmt.Fprintf(w, "  - %s %s: %s\n", b.CRD, b.Name, firmList(b.Firms))
+		}
+	}
+	if len(d.Moved) > 0 {
+		fmt.Fprintln(w, "\nMoved firm:")
+		for _, m := range d.Moved {
+			fmt.Fprintf(w, "  ~ %s %s: %s -> %s\n", m.CRD, m.Name, firmList(m.From), firmList(m.To))
+		}
+	}
+}
+
+// saveDiffJSON writes the machine-readable report
+func saveDiffJSON(d snapshotDiff, filename string) error {
+	out, err := createOutput(filename)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(d); err != nil {
+		return err
+	}
+	return out.Commit()
+}
+
+// runDiff implements the diff subcommand and returns the exit code
+func runDiff(args []string) int {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s diff [flags] old.json new.json\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	jsonOut := fs.String("json", "", `Also write the diff as JSON to this file; "-" writes it to stdout and the text report to stderr`)
+	fs.Parse(args)
+	if err := applyEnv(fs); err != nil {
+		log.Printf("diff: invalid environment setting: %v", err)
+		return 1
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return 1
+	}
+
+	var snapshots [2][]BrokerSource
+	for i, file := range fs.Args() {
+		brokers, err := loadBrokers(file)
+		if err != nil {
+			logErrorf("diff: %v", err)
+			return 1
+		}
+		snapshots[i] = brokers
+	}
+	d := diffSnapshots(snapshots[0], snapshots[1])
+	d.Old, d.New = fs.Arg(0), fs.Arg(1)
+
+	text := io.Writer(os.Stdout)
+	if *jsonOut == stdoutName {
+		text = os.Stderr
+	}
+	writeDiffText(text, d)
+	if *jsonOut != "" {
+		if err := saveDiffJSON(d, *jsonOut); err != nil {
+			logErrorf("diff: writing %s: %v", *jsonOut, err)
+			return 1
+		}
+	}
+	return 0
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"os"
+	"time"
+)
+
+// Parse Failure Dumps
+// -dump-raw-on-error saves every response body that fails to parse, whole,
+// to its own file. The body in the error message is hard to read once a
+// terminal has wrapped or truncated it.
+
+// parseFailure is the on-disk form of one unparseable response
+type parseFailure struct {
+	Time  time.Time `json:"time"`
+	URL   string    `json:"url"`
+	Error string    `json:"error"`
+	Body  string    `json:"body"`
+}
+
+// dumpBody writes body to a new timestamped file in Config.DumpDir and
+// returns its path, or "" if dumping is off or the file couldn't be written
+func (s *Scraper) dumpBody(url string, body []byte, parseErr error) string {
+	if s.Config.DumpDir == "" {
+		return ""
+	}
+	if err := os.MkdirAll(s.Config.DumpDir, 0755); err != nil {
+		logErrorf("Error creating -dump-raw-on-error directory: %v", err)
+		return ""
+	}
+	now := time.Now()
+	// Unescaped, so an HTML error page reads as HTML
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(parseFailure{Time: now, URL: url, Error: parseErr.Error(), Body: string(body)}); err != nil {
+		logErrorf("Error encoding unparseable response from %s: %v", url, err)
+		return ""
+	}
+	// CreateTemp's random suffix keeps concurrent failures in the same
+	// millisecond apart
+	file, err := os.CreateTemp(s.Config.DumpDir, "parse-error-"+now.Format("20060102T150405.000")+"-*.json")
+	if err == nil {
+		_, err = file.Write(buf.Bytes())
+		if cerr := file.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		logErrorf("Error saving unparseable response from %s: %v", url, err)
+		return ""
+	}
+	log.Printf("Saved unparseable response from %s to %s", url, file.Name())
+	return file.Name()
+}
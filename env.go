@@ -0,0 +1,45 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Environment Configuration
+// Every flag can also be set from an environment variable named after it,
+// e.g. BROKERCHECK_MAX_CONCURRENT for -max-concurrent, which suits
+// containers. A flag given on the command line wins over the environment,
+// which wins over the flag's default.
+
+const envPrefix = "BROKERCHECK_"
+
+// envName is the environment variable read for a flag
+func envName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// applyEnv sets every flag in fs that wasn't given on the command line
+// from its environment variable, if that is set
+func applyEnv(fs *flag.FlagSet) error {
+	given := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if err != nil || given[f.Name] {
+			return
+		}
+		value, ok := os.LookupEnv(envName(f.Name))
+		if !ok {
+			return
+		}
+		if setErr := fs.Set(f.Name, value); setErr != nil {
+			err = fmt.Errorf("%s=%q: %w", envName(f.Name), value, setErr)
+		}
+	})
+	return err
+}
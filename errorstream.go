@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+	"time"
+)
+
+// Error Stream
+// With -error-stream every fetch error is also written as one JSON object
+// per line, so scrape health can be monitored without parsing log text.
+
+// ErrorEvent describes one failed request
+type ErrorEvent struct {
+	Time   time.Time
+	Phase  string // PhaseSearch or PhaseDetail
+	Page   int    // 1-based search page (search phase only)
+	Offset int    // Search offset (search phase only)
+	CRD    string // Broker being looked up (detail phase only)
+	Err    error
+}
+
+// errorLine is how an ErrorEvent is written to the stream
+type errorLine struct {
+	Timestamp string `json:"timestamp"`
+	Phase     string `json:"phase"`
+	Page      *int   `json:"page,omitempty"`
+	Offset    *int   `json:"offset,omitempty"`
+	CRD       string `json:"crd,omitempty"`
+	Error     string `json:"error"`
+	Category  string `json:"category,omitempty"`
+	Status    int    `json:"status,omitempty"`
+	URL       string `json:"url,omitempty"`
+}
+
+// errorStream appends ErrorEvents to a file as JSON Lines. Record is safe
+// to call from several goroutines.
+type errorStream struct {
+	mu   sync.Mutex
+	file *os.File
+	enc  *json.Encoder
+}
+
+func openErrorStream(filename string) (*errorStream, error) {
+	file, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &errorStream{file: file, enc: json.NewEncoder(file)}, nil
+}
+
+// Record writes one event as a JSON line
+func (es *errorStream) Record(ev ErrorEvent) {
+	line := errorLine{
+		Timestamp: ev.Time.UTC().Format(time.RFC3339Nano),
+		Phase:     ev.Phase,
+		CRD:       ev.CRD,
+		Error:     ev.Err.Error(),
+		Category:  string(fetchCategory(ev.Err)),
+		URL:       errorURL(ev.Err),
+	}
+	var fe *FetchError
+	if errors.As(ev.Err, &fe) {
+		line.Status = fe.StatusCode
+	}
+	if ev.Phase == PhaseSearch {
+		line.Page, line.Offset = &ev.Page, &ev.Offset
+	}
+
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	if err := es.enc.Encode(line); err != nil {
+		logErrorf("Error writing to error stream: %v", err)
+	}
+}
+
+func (es *errorStream) Close() error {
+	return es.file.Close()
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Fetch Errors
+// Every request failure comes back as a *FetchError saying what kind of
+// failure it was, so retries and reports can tell a flaky connection from
+// a refused request or a response we couldn't read.
+
+// FetchCategory is the kind of failure a FetchError describes
+type FetchCategory string
+
+const (
+	CategoryNetwork FetchCategory = "network" // No complete response: connection, timeout, truncated or corrupt body
+	CategoryStatus  FetchCategory = "status"  // The server answered with a non-200 status
+	CategoryParse   FetchCategory = "parse"   // A 200 whose body didn't decode
+)
+
+// FetchError is a failed API request. It carries the request URL so any
+// failure can be reproduced, e.g. with curl.
+type FetchError struct {
+	Category   FetchCategory
+	StatusCode int // The response status, for CategoryStatus
+	URL        string
+	Err        error         // The underlying error, nil for CategoryStatus
+	RetryAfter time.Duration // The response's Retry-After, if it sent one
+}
+
+func (e *FetchError) Error() string {
+	if e.Category == CategoryStatus {
+		return fmt.Sprintf("bad status code: %d for URL: %s", e.StatusCode, e.URL)
+	}
+	return fmt.Sprintf("%v (URL: %s)", e.Err, e.URL)
+}
+
+func (e *FetchError) Unwrap() error { return e.Err }
+
+// fetchCategory returns the category of err, or "" if it isn't a
+// FetchError (e.g. the context was cancelled before the request)
+func fetchCategory(err error) FetchCategory {
+	var fe *FetchError
+	if errors.As(err, &fe) {
+		return fe.Category
+	}
+	return ""
+}
+
+// failureHint suggests what to look at after a request failed, for the
+// message of a scrape that collected nothing
+func failureHint(err error) string {
+	var fe *FetchError
+	if !errors.As(err, &fe) {
+		return ""
+	}
+	switch {
+	case fe.Category == CategoryNetwork:
+		return "check the network connection and proxy settings, or raise -page-timeout"
+	case fe.Category == CategoryParse:
+		return "the API's response format may have changed; run the check subcommand, or save the body with -dump-raw-on-error"
+	case fe.StatusCode == http.StatusTooManyRequests || fe.StatusCode == http.StatusServiceUnavailable:
+		return "the API is rate limiting this client; lower -rps or try again later"
+	case fe.StatusCode == http.StatusForbidden || fe.StatusCode == http.StatusUnauthorized:
+		return "the API refused the request; check -referer, -origin and any -header-file, or send from another address with -source-ips"
+	case fe.StatusCode >= 500:
+		return "the API is failing on its side; try again later"
+	case fe.StatusCode >= 400:
+		return "the API rejected the search; check the coordinates, radius and filters"
+	}
+	return ""
+}
+
+// errorURL returns the request URL carried by err, if any
+func errorURL(err error) string {
+	var fe *FetchError
+	if errors.As(err, &fe) {
+		return fe.URL
+	}
+	var ue *url.Error
+	if errors.As(err, &ue) {
+		return ue.URL
+	}
+	return ""
+}
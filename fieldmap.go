@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Field Mapping
+// FINRA occasionally renames JSON keys, which silently turns fields empty.
+// A field map (-field-map) lists alternate key names for the keys our
+// structs expect, so a rename can be absorbed without a new release.
+
+// FieldMap maps a JSON key we decode (e.g. "ind_source_id") to alternate
+// keys to read it from when it is missing. It applies to the _source
+// object and to each employment inside it.
+type FieldMap map[string][]string
+
+// employmentKeys are the _source keys holding lists of Employment objects
+var employmentKeys = []string{"ind_current_employments", "ind_previous_employments"}
+
+// loadFieldMap reads a JSON object of key → [alternates...] and checks that
+// every key is one we actually decode
+func loadFieldMap(filename string) (FieldMap, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var m FieldMap
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	known := append(jsonKeys(BrokerSource{}), jsonKeys(Employment{})...)
+	for key := range m {
+		if !contains(known, key) {
+			sort.Strings(known)
+			return nil, fmt.Errorf("%s: unknown field %q (known: %s)", filename, key, strings.Join(known, ", "))
+		}
+	}
+	return m, nil
+}
+
+// jsonKeys lists the JSON keys a struct decodes
+func jsonKeys(v any) []string {
+	var keys []string
+	t := reflect.TypeOf(v)
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			keys = append(keys, name)
+		}
+	}
+	return keys
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// remapObject fills missing keys of obj from their alternates and returns
+// the "alternate→key" pairs it used
+func (m FieldMap) remapObject(obj map[string]json.RawMessage) []string {
+	var used []string
+	for key, alts := range m {
+		if _, ok := obj[key]; ok {
+			continue
+		}
+		for _, alt := range alts {
+			if v, ok := obj[alt]; ok {
+				obj[key] = v
+				used = append(used, alt+"→"+key)
+				break
+			}
+		}
+	}
+	return used
+}
+
+// Apply remaps a raw _source object, including the employments inside it.
+// src is returned unchanged when no alternate key was needed.
+func (m FieldMap) Apply(src json.RawMessage) (json.RawMessage, []string, error) {
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(src, &obj); err != nil || obj == nil {
+		return src, nil, err
+	}
+	used := m.remapObject(obj)
+	for _, key := range employmentKeys {
+		list, ok := obj[key]
+		if !ok {
+			continue
+		}
+		var emps []map[string]json.RawMessage
+		if err := json.Unmarshal(list, &emps); err != nil {
+			continue // Leave it for the normal decode to report
+		}
+		var empUsed []string
+		for _, emp := range emps {
+			empUsed = append(empUsed, m.remapObject(emp)...)
+		}
+		if len(empUsed) > 0 {
+			data, err := json.Marshal(emps)
+			if err != nil {
+				return src, nil, err
+			}
+			obj[key] = data
+			used = append(used, empUsed...)
+		}
+	}
+	if len(used) == 0 {
+		return src, nil, nil
+	}
+	data, err := json.Marshal(obj)
+	return data, used, err
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/vm"
+)
+
+// Filter Expressions
+// -filter takes an expr-lang expression (https://expr-lang.org) that is
+// evaluated against each broker; brokers for which it is false are dropped.
+
+// filterEnv is what a -filter expression can see. The firm fields describe
+// the broker's first current employment, like the default CSV row does.
+type filterEnv struct {
+	CRD               string   `expr:"crd"`
+	FirstName         string   `expr:"first_name"`
+	LastName          string   `expr:"last_name"`
+	IndustryStartDate string   `expr:"industry_start_date"`
+	YearsExperience   float64  `expr:"years_experience"`
+	FirmCRD           string   `expr:"firm_crd"`
+	FirmName          string   `expr:"firm_name"`
+	City              string   `expr:"city"`
+	State             string   `expr:"state"`
+	Zip               string   `expr:"zip"`
+	NumFirms          int      `expr:"num_firms"`
+	NumPreviousFirms  int      `expr:"num_previous_firms"`
+	States            []string `expr:"states"`
+	FirmNames         []string `expr:"firm_names"`
+}
+
+func newFilterEnv(b *BrokerSource, now time.Time) filterEnv {
+	env := filterEnv{
+		CRD:               b.CRD,
+		FirstName:         b.FirstName,
+		LastName:          b.LastName,
+		IndustryStartDate: b.IndustryStartDate,
+		YearsExperience:   yearsExperience(b, now),
+		NumFirms:          len(b.CurrentEmployments),
+		NumPreviousFirms:  len(b.PreviousEmployments),
+	}
+	for i, emp := range b.CurrentEmployments {
+		if i == 0 {
+			env.FirmCRD, env.FirmName = emp.FirmCRD, emp.FirmName
+			env.City, env.State, env.Zip = emp.City, emp.State, emp.Zip
+		}
+		env.States = append(env.States, emp.State)
+		env.FirmNames = append(env.FirmNames, emp.FirmName)
+	}
+	return env
+}
+
+// compileFilter checks a -filter expression up front, so typos and unknown
+// fields fail before the scrape rather than after it
+func compileFilter(src string) (*vm.Program, error) {
+	return expr.Compile(src, expr.Env(filterEnv{}), expr.AsBool())
+}
+
+// filterByExpr keeps the brokers for which prog evaluates to true. A broker
+// prog fails on is dropped, and the error, naming its CRD, is returned in
+// errs.
+func filterByExpr(brokers []BrokerSource, prog *vm.Program) (kept []BrokerSource, errs []error) {
+	now := time.Now()
+	for i := range brokers {
+		out, err := expr.Run(prog, newFilterEnv(&brokers[i], now))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("CRD %s: %w", brokers[i].CRD, err))
+			continue
+		}
+		if out.(bool) {
+			kept = append(kept, brokers[i])
+		}
+	}
+	return kept, errs
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Geographic Consistency
+// -geo-check is a sanity check on the search itself: a search around D.C.
+// should return brokers employed in DC, VA, MD and their neighbours, so many
+// brokers in distant states suggest the coordinates were swapped, mistyped
+// or the radius is far too big. The only location the API gives is the
+// branch state, so this compares state centers and is deliberately loose.
+
+const (
+	// geoMargin is how far (in miles) a state's center may lie beyond a
+	// search circle and still count as nearby; it allows for the size of
+	// the state (a border branch is far from its state's center)
+	geoMargin = 300.0
+
+	earthRadiusMiles = 3958.8
+)
+
+// milesBetween is the great-circle distance between two points
+func milesBetween(lat1, lon1, lat2, lon2 float64) float64 {
+	rad := math.Pi / 180
+	dLat, dLon := (lat2-lat1)*rad, (lon2-lon1)*rad
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1*rad)*math.Cos(lat2*rad)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusMiles * math.Asin(math.Sqrt(a))
+}
+
+// nearbyStates returns the codes of the states whose center is within
+// geoMargin of any of the search circles
+func nearbyStates(regions []searchRegion) map[string]bool {
+	near := make(map[string]bool)
+	for _, r := range regions {
+		lat, errLat := strconv.ParseFloat(r.Lat, 64)
+		lon, errLon := strconv.ParseFloat(r.Lon, 64)
+		radius, errR := strconv.ParseFloat(r.Radius, 64)
+		if errLat != nil || errLon != nil || errR != nil {
+			continue
+		}
+		for _, st := range usStates {
+			stLat, _ := strconv.ParseFloat(st.Lat, 64)
+			stLon, _ := strconv.ParseFloat(st.Lon, 64)
+			if milesBetween(lat, lon, stLat, stLon) <= radius+geoMargin {
+				near[st.Code] = true
+			}
+		}
+	}
+	return near
+}
+
+// checkGeography counts the brokers whose first current employment is in
+// a known state far from every search circle, and warns when they are
+// more than threshold (0-1) of those with a known state. It returns the
+// number far away and the number checked.
+func checkGeography(brokers []BrokerSource, regions []searchRegion, threshold float64) (far, checked int) {
+	near := nearbyStates(regions)
+	farStates := make(map[string]int)
+	for _, b := range brokers {
+		if len(b.CurrentEmployments) == 0 {
+			continue
+		}
+		st, ok := lookupState(strings.TrimSpace(b.CurrentEmployments[0].State))
+		if !ok {
+			continue
+		}
+		checked++
+		if !near[st.Code] {
+			far++
+			farStates[st.Code]++
+		}
+	}
+	if checked == 0 {
+		return 0, 0
+	}
+
+	ratio := float64(far) / float64(checked)
+	if ratio <= threshold {
+		log.Printf("Geo check: %d of %d brokers are employed in states near the search", checked-far, checked)
+		return far, checked
+	}
+	codes := make([]string, 0, len(farStates))
+	for code := range farStates {
+		codes = append(codes, code)
+	}
+	sort.Slice(codes, func(i, j int) bool {
+		if farStates[codes[i]] != farStates[codes[j]] {
+			return farStates[codes[i]] > farStates[codes[j]]
+		}
+		return codes[i] < codes[j]
+	})
+	var top []string
+	for _, code := range codes[:min(len(codes), 5)] {
+		top = append(top, fmt.Sprintf("%s %d", code, farStates[code]))
+	}
+	log.Printf("Warning: %.0f%% of brokers (%d of %d) are employed in states far from the search (%s); check the coordinates and radius",
+		ratio*100, far, checked, strings.Join(top, ", "))
+	return far, checked
+}
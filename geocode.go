@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Geocoding
+// -zip and -city set the search center from a place instead of raw
+// coordinates, offline. ZIPs are looked up in a table built into the
+// binary, which like the county table only covers the default D.C. search,
+// with the rows of -zip-coords (e.g. a national ZCTA table) added over it.
+// Cities are the -region presets' centers, by preset name or full name.
+// A place in neither is looked up with -geocoder, a Nominatim-compatible
+// search service, when one is given; nothing goes online without it.
+
+//go:embed zipcoords.csv
+var builtinZipCoords string
+
+// zipPattern matches a five-digit ZIP, optionally with its +4
+var zipPattern = regexp.MustCompile(`^[0-9]{5}(-?[0-9]{4})?$`)
+
+// cityAliases maps full city names to the presets named otherwise
+var cityAliases = map[string]string{
+	"los angeles":      "la",
+	"new york":         "nyc",
+	"new york city":    "nyc",
+	"san francisco":    "sf",
+	"washington":       "dc",
+	"washington dc":    "dc",
+	"washington, dc":   "dc",
+	"washington, d.c.": "dc",
+}
+
+// geocodeTimeout caps a -geocoder lookup
+const geocodeTimeout = 15 * time.Second
+
+// geocodeZip returns the coordinates of zip from the built-in table and
+// coordsFile, if given, or else from geocoder, if given
+func geocodeZip(zip, coordsFile, geocoder string) (lat, lon string, err error) {
+	zip = strings.TrimSpace(zip)
+	if !zipPattern.MatchString(zip) {
+		return "", "", fmt.Errorf("%q isn't a 5-digit ZIP code", zip)
+	}
+	coords := make(map[string]Point)
+	if err := parseZipCoords(strings.NewReader(builtinZipCoords), "built-in table", coords); err != nil {
+		return "", "", err
+	}
+	if coordsFile != "" {
+		extra, err := loadZipCoords(coordsFile)
+		if err != nil {
+			return "", "", fmt.Errorf("-zip-coords: %w", err)
+		}
+		for z, pt := range extra {
+			coords[z] = pt
+		}
+	}
+	pt, ok := coords[zip5(zip)]
+	if !ok {
+		if geocoder != "" {
+			return lookupPlace(geocoder, "postalcode", zip5(zip))
+		}
+		if coordsFile == "" {
+			return "", "", fmt.Errorf("ZIP %s isn't in the built-in table, which only covers the D.C. area; give a national zip,lat,lon table with -zip-coords, look it up with -geocoder, or use -lat/-lon", zip5(zip))
+		}
+		return "", "", fmt.Errorf("ZIP %s is in neither the built-in table nor %s", zip5(zip), coordsFile)
+	}
+	return strconv.FormatFloat(pt.Lat, 'f', 6, 64), strconv.FormatFloat(pt.Lon, 'f', 6, 64), nil
+}
+
+// geocodeCity returns the center of the preset city names, or looks any
+// other city up with geocoder, if given
+func geocodeCity(city, geocoder string) (lat, lon string, err error) {
+	name := strings.ToLower(strings.Join(strings.Fields(city), " "))
+	if alias, ok := cityAliases[name]; ok {
+		name = alias
+	}
+	preset, ok := regionPresets[name]
+	if !ok {
+		if geocoder != "" {
+			return lookupPlace(geocoder, "q", strings.TrimSpace(city))
+		}
+		return "", "", fmt.Errorf("unknown city %q (available: %s; or look it up with -geocoder, or use -zip or -lat/-lon)", city, strings.Join(regionNames(), ", "))
+	}
+	return preset.Lat, preset.Lon, nil
+}
+
+// geocodeMatch is one result of a Nominatim search
+type geocodeMatch struct {
+	Lat         string `json:"lat"`
+	Lon         string `json:"lon"`
+	DisplayName string `json:"display_name"`
+}
+
+// lookupPlace asks geocoder, a Nominatim-compatible search endpoint, for
+// the best U.S. match of the search param=value
+func lookupPlace(geocoder, param, value string) (lat, lon string, err error) {
+	u, err := url.Parse(geocoder)
+	if err != nil {
+		return "", "", fmt.Errorf("-geocoder: %w", err)
+	}
+	q := u.Query()
+	q.Set(param, value)
+	q.Set("countrycodes", "us")
+	q.Set("format", "jsonv2")
+	q.Set("limit", "1")
+	u.RawQuery = q.Encode()
+
+	ctx, cancel := context.WithTimeout(context.Background(), geocodeTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+	if err != nil {
+		return "", "", fmt.Errorf("-geocoder: %w", err)
+	}
+	// Nominatim's usage policy asks for an identifying User-Agent
+	req.Header.Set("User-Agent", "brokercheck-scraper")
+	req.Header.Set("Accept", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", "", fmt.Errorf("-geocoder: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", "", fmt.Errorf("-geocoder: %s returned status %d", u.Host, resp.StatusCode)
+	}
+	var matches []geocodeMatch
+	if err := json.NewDecoder(resp.Body).Decode(&matches); err != nil {
+		return "", "", fmt.Errorf("-geocoder: decoding the response: %w", err)
+	}
+	if len(matches) == 0 {
+		return "", "", fmt.Errorf("-geocoder found no U.S. place for %q", value)
+	}
+	m := matches[0]
+	latF, err1 := strconv.ParseFloat(m.Lat, 64)
+	lonF, err2 := strconv.ParseFloat(m.Lon, 64)
+	if err1 != nil || err2 != nil {
+		return "", "", fmt.Errorf("-geocoder returned unusable coordinates %q, %q for %q", m.Lat, m.Lon, value)
+	}
+	log.Printf("Geocoder: %q isn't in the built-in tables; %s placed it at %s", value, u.Host, m.DisplayName)
+	return strconv.FormatFloat(latF, 'f', 6, 64), strconv.FormatFloat(lonF, 'f', 6, 64), nil
+}
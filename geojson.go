@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// GeoJSON output
+// The search API doesn't return coordinates for branch offices, so each
+// branch is placed by looking its ZIP code up in a user-supplied table.
+
+// Point is a latitude/longitude pair
+type Point struct {
+	Lat float64
+	Lon float64
+}
+
+type geoFeatureCollection struct {
+	Type     string       `json:"type"`
+	Features []geoFeature `json:"features"`
+}
+
+type geoFeature struct {
+	Type       string            `json:"type"`
+	Geometry   geoPoint          `json:"geometry"`
+	Properties map[string]string `json:"properties"`
+}
+
+type geoPoint struct {
+	Type        string     `json:"type"`
+	Coordinates [2]float64 `json:"coordinates"` // GeoJSON order is [lon, lat]
+}
+
+// loadZipCoords reads a CSV of zip,lat,lon rows (a header row is skipped if
+// present), e.g. the Census Bureau's ZCTA gazetteer reduced to three columns.
+func loadZipCoords(filename string) (map[string]Point, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	coords := make(map[string]Point)
+	if err := parseZipCoords(file, filename, coords); err != nil {
+		return nil, err
+	}
+	return coords, nil
+}
+
+// parseZipCoords adds the zip,lat,lon rows of r to coords. Lines starting
+// with # are skipped.
+func parseZipCoords(r io.Reader, name string, coords map[string]Point) error {
+	reader := csv.NewReader(r)
+	reader.Comment = '#'
+	reader.FieldsPerRecord = 3
+	reader.TrimLeadingSpace = true
+
+	for first := true; ; first = false {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		lat, latErr := strconv.ParseFloat(record[1], 64)
+		lon, lonErr := strconv.ParseFloat(record[2], 64)
+		if latErr != nil || lonErr != nil {
+			if first {
+				continue // header row
+			}
+			line, _ := reader.FieldPos(1)
+			return fmt.Errorf("%s line %d: invalid coordinates %q, %q", name, line, record[1], record[2])
+		}
+		coords[zip5(record[0])] = Point{Lat: lat, Lon: lon}
+	}
+}
+
+// zip5 reduces a ZIP or ZIP+4 to its five-digit prefix
+func zip5(zip string) string {
+	zip = strings.TrimSpace(zip)
+	if len(zip) > 5 {
+		zip = zip[:5]
+	}
+	return zip
+}
+
+// saveToGeoJSON writes a FeatureCollection with one Point per current
+// employment. Employments whose ZIP can't be resolved are left out and
+// counted in the log.
+func saveToGeoJSON(data []BrokerSource, filename string, zipCoords map[string]Point) (int, error) {
+	collection := geoFeatureCollection{Type: "FeatureCollection", Features: []geoFeature{}}
+	unresolved := 0
+
+	for _, broker := range data {
+		for _, emp := range broker.CurrentEmployments {
+			pt, ok := zipCoords[zip5(emp.Zip)]
+			if !ok {
+				unresolved++
+				continue
+			}
+			collection.Features = append(collection.Features, geoFeature{
+				Type:     "Feature",
+				Geometry: geoPoint{Type: "Point", Coordinates: [2]float64{pt.Lon, pt.Lat}},
+				Properties: map[string]string{
+					"crd":         broker.CRD,
+					"first_name":  broker.FirstName,
+					"last_name":   broker.LastName,
+					"firm_crd":    emp.FirmCRD,
+					"firm_name":   emp.FirmName,
+					"city":        emp.City,
+					"state":       emp.State,
+					"zip":         emp.Zip,
+					"profile_url": broker.ProfileURL,
+				},
+			})
+		}
+	}
+
+	if unresolved > 0 {
+		log.Printf("GeoJSON: %d employments omitted because their ZIP has no known coordinates", unresolved)
+	}
+
+	file, err := json.MarshalIndent(collection, "", "  ")
+	if err != nil {
+		logErrorf("Error marshaling GeoJSON: %v", err)
+		return 0, err
+	}
+	err = writeFileAtomic(filename, file)
+	if err != nil {
+		logErrorf("Error writing GeoJSON file: %v", err)
+		return 0, err
+	}
+	log.Printf("Successfully saved to %s", filename)
+	return len(collection.Features), nil
+}
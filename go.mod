@@ -2,6 +2,13 @@ module brokercheck-scraper
 
 go 1.25.3
 
+require (
+	github.com/expr-lang/expr v1.17.8
+	github.com/lib/pq v1.10.9
+	golang.org/x/text v0.23.0
+	golang.org/x/time v0.12.0
+)
+
 require (
 	github.com/PuerkitoBio/goquery v1.10.2 // indirect
 	github.com/andybalholm/cascadia v1.3.3 // indirect
@@ -18,7 +25,6 @@ require (
 	github.com/saintfish/chardet v0.0.0-20230101081208-5e3ef4b5456d // indirect
 	github.com/temoto/robotstxt v1.1.2 // indirect
 	golang.org/x/net v0.37.0 // indirect
-	golang.org/x/text v0.23.0 // indirect
 	google.golang.org/appengine v1.6.8 // indirect
 	google.golang.org/protobuf v1.36.6 // indirect
 )
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Coverage Grids
+// -grid-file scrapes a list of search circles planned elsewhere (e.g. a
+// national coverage grid from a GIS tool) instead of the -region presets.
+// Each row is one search; they run like a multi-region scrape, with the
+// overlap between cells removed by the normal dedup.
+
+// loadGrid reads lat,lon,radius rows, with an optional fourth name column,
+// into searches named grid001, grid002, ... unless named. Radii are in
+// unit. A header row is skipped if its latitude isn't a number.
+func loadGrid(filename, unit string) ([]searchRegion, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	reader.Comment = '#'
+	reader.TrimLeadingSpace = true
+
+	var cells []searchRegion
+	seen := make(map[string]bool)
+	for first := true; ; first = false {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, _ := reader.FieldPos(0)
+		if len(row) < 3 || len(row) > 4 {
+			return nil, fmt.Errorf("line %d: want lat,lon,radius[,name], got %d fields", line, len(row))
+		}
+		lat, errLat := strconv.ParseFloat(strings.TrimSpace(row[0]), 64)
+		if errLat != nil && first {
+			continue // Header
+		}
+		lon, errLon := strconv.ParseFloat(strings.TrimSpace(row[1]), 64)
+		r, errR := strconv.ParseFloat(strings.TrimSpace(row[2]), 64)
+		switch {
+		case errLat != nil || lat < -90 || lat > 90:
+			return nil, fmt.Errorf("line %d: latitude %q must be a number from -90 to 90", line, row[0])
+		case errLon != nil || lon < -180 || lon > 180:
+			return nil, fmt.Errorf("line %d: longitude %q must be a number from -180 to 180", line, row[1])
+		case errR != nil || r <= 0:
+			return nil, fmt.Errorf("line %d: radius %q must be a number above 0", line, row[2])
+		}
+
+		name := fmt.Sprintf("grid%03d", len(cells)+1)
+		if len(row) == 4 && strings.TrimSpace(row[3]) != "" {
+			name = strings.TrimSpace(row[3])
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("line %d: cell name %q repeated", line, name)
+		}
+		seen[name] = true
+		cells = append(cells, searchRegion{Name: name, regionPreset: regionPreset{
+			Lat:    strings.TrimSpace(row[0]),
+			Lon:    strings.TrimSpace(row[1]),
+			Radius: formatRadius(toMiles(r, unit)),
+		}})
+	}
+	if len(cells) == 0 {
+		return nil, fmt.Errorf("no grid cells in %s", filename)
+	}
+	return cells, nil
+}
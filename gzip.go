@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// Compressed Responses
+// We ask for gzip ourselves instead of letting net/http do it, because the
+// automatic handling trusts Content-Encoding: it fails on a labeled body
+// that isn't gzip and passes through an unlabeled one that is. Sniffing
+// the gzip magic bytes gets both cases right.
+
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// isGzip reports whether body starts with the gzip magic bytes
+func isGzip(body []byte) bool {
+	return bytes.HasPrefix(body, gzipMagic)
+}
+
+// gunzip decompresses body if it is gzip, and returns it as-is otherwise
+func gunzip(body []byte) ([]byte, error) {
+	if !isGzip(body) {
+		return body, nil
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	return io.ReadAll(zr)
+}
+
+// decodeBody decompresses a response body based on its content, logging
+// when the Content-Encoding header said otherwise
+func decodeBody(header http.Header, url string, body []byte) ([]byte, error) {
+	labeled := strings.EqualFold(header.Get("Content-Encoding"), "gzip")
+	if compressed := isGzip(body); compressed != labeled {
+		if compressed {
+			log.Printf("Response from %s is gzip but not labeled as such; decompressing anyway", url)
+		} else {
+			log.Printf("Response from %s is labeled gzip but isn't compressed; reading it as-is", url)
+		}
+	}
+	return gunzip(body)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Header Files
+// -header-file adds a set of request headers kept in a file, one
+// "Name: Value" per line like a curl header file, e.g. to send a full
+// browser header set. They are applied to every request after the
+// built-in ones, replacing any of the same name.
+
+// headerName matches a valid header field name (an RFC 9110 token)
+var headerName = regexp.MustCompile("^[!#$%&'*+.^_`|~0-9A-Za-z-]+$")
+
+// managedHeaders are set by the scraper or net/http itself and can't come
+// from a header file: the body decoder only understands gzip, and the
+// others describe the connection
+var managedHeaders = map[string]bool{"Accept-Encoding": true, "Host": true, "Content-Length": true, "Connection": true}
+
+// loadHeaderFile reads Name: Value lines. Blank lines and lines starting
+// with # are skipped; a name given twice sends both values.
+func loadHeaderFile(filename string) (http.Header, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	headers := make(http.Header)
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		name, value, ok := strings.Cut(text, ":")
+		name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+		switch {
+		case !ok:
+			return nil, fmt.Errorf("line %d: want \"Name: Value\", got %q", line, text)
+		case !headerName.MatchString(name):
+			return nil, fmt.Errorf("line %d: invalid header name %q", line, name)
+		case strings.ContainsFunc(value, func(r rune) bool { return r < ' ' && r != '\t' || r == 0x7f }):
+			return nil, fmt.Errorf("line %d: control character in the %s value", line, name)
+		case managedHeaders[http.CanonicalHeaderKey(name)]:
+			return nil, fmt.Errorf("line %d: %s is set by the scraper and can't be overridden", line, http.CanonicalHeaderKey(name))
+		}
+		headers.Add(name, value)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return headers, nil
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"html/template"
+	"log"
+	"sort"
+	"time"
+)
+
+// HTML Report
+// A single self-contained page (inline CSS and JS) so it can be emailed or
+// opened straight from disk.
+
+// reportSummary is the header block at the top of the HTML report
+type reportSummary struct {
+	Generated string
+	Search    string
+	Brokers   int
+	Firms     int
+	TopStates []stateCount
+	Rows      []reportRow
+}
+
+type stateCount struct {
+	State string
+	Count int
+}
+
+type reportRow struct {
+	CRD        string
+	ProfileURL string
+	FirstName  string
+	LastName   string
+	Firms      string
+	City       string
+	State      string
+	Zip        string
+}
+
+// saveToHTML writes a browsable report of the brokers. search describes the
+// query that produced them and is shown in the summary.
+func saveToHTML(data []BrokerSource, filename, search string) (int, error) {
+	summary := reportSummary{
+		Generated: time.Now().Format("2006-01-02 15:04 MST"),
+		Search:    search,
+		Brokers:   len(data),
+	}
+
+	firms := make(map[string]bool)
+	states := make(map[string]int)
+	for _, broker := range data {
+		row := reportRow{CRD: broker.CRD, ProfileURL: broker.ProfileURL, FirstName: broker.FirstName, LastName: broker.LastName}
+		for i, emp := range broker.CurrentEmployments {
+			firms[emp.FirmName] = true
+			if i == 0 {
+				row.City, row.State, row.Zip = emp.City, emp.State, emp.Zip
+				row.Firms = emp.FirmName
+				states[emp.State]++
+			} else {
+				row.Firms += "; " + emp.FirmName
+			}
+		}
+		summary.Rows = append(summary.Rows, row)
+	}
+	summary.Firms = len(firms)
+
+	for state, count := range states {
+		summary.TopStates = append(summary.TopStates, stateCount{State: state, Count: count})
+	}
+	sort.Slice(summary.TopStates, func(i, j int) bool {
+		if summary.TopStates[i].Count != summary.TopStates[j].Count {
+			return summary.TopStates[i].Count > summary.TopStates[j].Count
+		}
+		return summary.TopStates[i].State < summary.TopStates[j].State
+	})
+
+	var buf bytes.Buffer
+	if err := reportTemplate.Execute(&buf, summary); err != nil {
+		logErrorf("Error rendering HTML report: %v", err)
+		return 0, err
+	}
+	err := writeFileAtomic(filename, buf.Bytes())
+	if err != nil {
+		logErrorf("Error writing HTML file: %v", err)
+		return 0, err
+	}
+	log.Printf("Successfully saved to %s", filename)
+	return len(data), nil
+}
+
+var reportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>BrokerCheck scrape report</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; margin: 2em; color: #222; }
+h1 { font-size: 1.4em; }
+.summary { display: flex; gap: 2em; flex-wrap: wrap; margin-bottom: 1em; }
+.summary div { background: #f3f5f7; padding: .6em 1em; border-radius: 4px; }
+.summary b { display: block; font-size: 1.3em; }
+input { padding: .4em; width: 20em; margin-bottom: .8em; }
+table { border-collapse: collapse; width: 100%; font-size: .9em; }
+th, td { border-bottom: 1px solid #ddd; padding: .35em .6em; text-align: left; }
+th { background: #fafafa; cursor: pointer; user-select: none; position: sticky; top: 0; }
+th.asc::after { content: " \25B2"; }
+th.desc::after { content: " \25BC"; }
+tr:hover td { background: #f7fbff; }
+</style>
+</head>
+<body>
+<h1>BrokerCheck scrape report</h1>
+<p>Search: {{.Search}} &middot; Generated {{.Generated}}</p>
+<div class="summary">
+<div><b>{{.Brokers}}</b>brokers</div>
+<div><b>{{.Firms}}</b>firms</div>
+{{range $i, $s := .TopStates}}{{if lt $i 5}}<div><b>{{$s.Count}}</b>in {{if $s.State}}{{$s.State}}{{else}}no state{{end}}</div>{{end}}{{end}}
+</div>
+<input id="filter" type="search" placeholder="Filter rows...">
+<table id="brokers">
+<thead><tr><th>CRD</th><th>First name</th><th>Last name</th><th>Firm(s)</th><th>City</th><th>State</th><th>ZIP</th></tr></thead>
+<tbody>
+{{range .Rows}}<tr><td>{{if .ProfileURL}}<a href="{{.ProfileURL}}">{{.CRD}}</a>{{else}}{{.CRD}}{{end}}</td><td>{{.FirstName}}</td><td>{{.LastName}}</td><td>{{.Firms}}</td><td>{{.City}}</td><td>{{.State}}</td><td>{{.Zip}}</td></tr>
+{{end}}</tbody>
+</table>
+<script>
+(function () {
+  var table = document.getElementById("brokers");
+  var body = table.tBodies[0];
+  var rows = Array.prototype.slice.call(body.rows);
+
+  document.getElementById("filter").addEventListener("input", function () {
+    var needle = this.value.toLowerCase();
+    rows.forEach(function (r) {
+      r.style.display = r.textContent.toLowerCase().indexOf(needle) === -1 ? "none" : "";
+    });
+  });
+
+  Array.prototype.forEach.call(table.tHead.rows[0].cells, function (th, col) {
+    th.addEventListener("click", function () {
+      var asc = !th.classList.contains("asc");
+      Array.prototype.forEach.call(th.parentNode.cells, function (c) { c.classList.remove("asc", "desc"); });
+      th.classList.add(asc ? "asc" : "desc");
+      rows.sort(function (a, b) {
+        var x = a.cells[col].textContent, y = b.cells[col].textContent;
+        var cmp = (col === 0) ? (Number(x) - Number(y)) : x.localeCompare(y);
+        return asc ? cmp : -cmp;
+      });
+      rows.forEach(function (r) { body.appendChild(r); });
+    });
+  });
+})();
+</script>
+</body>
+</html>
+`))
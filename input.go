@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// Reprocessing Saved Scrapes
+// -input loads brokers from an earlier run's output instead of the API, so
+// filters and formats can be re-run on an archived scrape offline.
+
+// loadBrokers reads a JSON array of brokers (brokers.json), one broker
+// object per line (brokers.ndjson, raw-brokers.ndjson) or a brokers.csv
+// (see csvinput.go), told apart by their first character. "-" reads
+// stdin. The derived fields are recomputed as of now.
+func loadBrokers(filename string) ([]BrokerSource, error) {
+	file, err := openInput(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	r := bufio.NewReader(file)
+	first, err := peekNonSpace(r)
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+
+	var brokers []BrokerSource
+	if first != '[' && first != '{' {
+		cr, err := newCSVBrokerReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", filename, err)
+		}
+		for {
+			b, err := cr.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", filename, err)
+			}
+			brokers = append(brokers, b)
+		}
+		deriveFields(brokers, time.Now())
+		return brokers, nil
+	}
+
+	dec := json.NewDecoder(r)
+	if first == '[' {
+		if err := dec.Decode(&brokers); err != nil {
+			return nil, fmt.Errorf("%s: %w", filename, err)
+		}
+	} else {
+		for {
+			var b BrokerSource
+			err := dec.Decode(&b)
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				return nil, fmt.Errorf("%s: record %d: %w", filename, len(brokers)+1, err)
+			}
+			brokers = append(brokers, b)
+		}
+	}
+	deriveFields(brokers, time.Now())
+	return brokers, nil
+}
+
+// openInput opens filename for reading, or returns stdin for "-"
+func openInput(filename string) (io.ReadCloser, error) {
+	if filename == stdoutName {
+		return io.NopCloser(os.Stdin), nil
+	}
+	return os.Open(filename)
+}
+
+// peekNonSpace returns the first non-whitespace byte without consuming it
+func peekNonSpace(r *bufio.Reader) (byte, error) {
+	for {
+		c, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		if !bytes.ContainsRune([]byte(" \t\r\n"), rune(c)) {
+			return c, r.UnreadByte()
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Interval Mode
+// -interval turns the scraper into a simple monitor without cron: scrape,
+// move every file the cycle wrote into a directory named after its start
+// time, sleep, and scrape again until SIGINT or SIGTERM. A signal while
+// sleeping exits cleanly; one mid-cycle saves that cycle's brokers, as a
+// single run would, and stops.
+
+// cycleDirLayout names each cycle's directory under -interval-dir, in UTC
+const cycleDirLayout = "20060102T150405Z"
+
+// runInterval repeats scrapeOnce every opts.Interval until ctx is done and
+// returns the exit status
+func runInterval(ctx context.Context, opts *options, progress *progressStream, errStream *errorStream) int {
+	for cycle := 1; ; cycle++ {
+		opts.started = time.Now()
+		log.Printf("Interval: starting cycle %d", cycle)
+		files, code := scrapeOnce(ctx, opts, progress, errStream)
+		if len(files) > 0 {
+			dir := filepath.Join(opts.IntervalDir, opts.started.UTC().Format(cycleDirLayout))
+			if err := moveFiles(files, dir); err != nil {
+				logErrorf("Interval: moving cycle %d's files into %s: %v", cycle, dir, err)
+			} else {
+				log.Printf("Interval: cycle %d saved %d files in %s", cycle, len(files), dir)
+			}
+		}
+		if ctx.Err() != nil {
+			return code
+		}
+		if code != 0 {
+			log.Printf("Warning: cycle %d finished with exit status %d; carrying on", cycle, code)
+		}
+
+		log.Printf("Interval: sleeping %s, until %s", opts.Interval, time.Now().Add(opts.Interval).Format(time.TimeOnly))
+		select {
+		case <-ctx.Done():
+			log.Printf("Interval: stopped by signal after %d cycles", cycle)
+			return 0
+		case <-time.After(opts.Interval):
+		}
+	}
+}
+
+// moveFiles moves the files at paths into dir, keeping relative paths
+// (e.g. a -group-output-by-state directory) and the base name of the rest.
+// Directories left empty by the move are removed.
+func moveFiles(paths []string, dir string) error {
+	for _, path := range paths {
+		dest := filepath.Join(dir, filepath.Base(path))
+		if filepath.IsLocal(path) {
+			dest = filepath.Join(dir, path)
+		}
+		if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+			return err
+		}
+		if err := os.Rename(path, dest); err != nil {
+			return err
+		}
+		if parent := filepath.Dir(path); filepath.IsLocal(path) && parent != "." {
+			os.Remove(parent) // Fails, harmlessly, until the directory is empty
+		}
+	}
+	return nil
+}
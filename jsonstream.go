@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+)
+
+// Streaming JSON Arrays
+// brokers.json is a single indented array, which json.MarshalIndent would
+// build in memory in full before the first byte is written. The array is
+// instead written one element at a time, byte-for-byte the same as
+// MarshalIndent's output, so memory stays flat however many brokers there are.
+
+// jsonFlushEvery is how many elements are buffered between flushes, so a
+// consumer reading from stdout sees the array grow
+const jsonFlushEvery = 100
+
+// jsonArrayWriter writes an indented JSON array element by element. Write
+// each element, then Close to end the array.
+type jsonArrayWriter struct {
+	w *bufio.Writer
+	n int
+}
+
+func newJSONArrayWriter(w io.Writer) *jsonArrayWriter {
+	return &jsonArrayWriter{w: bufio.NewWriter(w)}
+}
+
+// Write appends v to the array
+func (a *jsonArrayWriter) Write(v any) error {
+	data, err := json.MarshalIndent(v, "  ", "  ")
+	if err != nil {
+		return err
+	}
+	sep := ",\n  "
+	if a.n == 0 {
+		sep = "[\n  "
+	}
+	a.w.WriteString(sep)
+	a.w.Write(data)
+	a.n++
+	if a.n%jsonFlushEvery == 0 {
+		return a.w.Flush()
+	}
+	return nil
+}
+
+// Close writes the closing bracket (or "[]" for an empty array) and
+// flushes. It doesn't close the underlying writer.
+func (a *jsonArrayWriter) Close() error {
+	if a.n == 0 {
+		a.w.WriteString("[]\n")
+	} else {
+		a.w.WriteString("\n]\n")
+	}
+	return a.w.Flush()
+}
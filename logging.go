@@ -0,0 +1,47 @@
+package main
+
+import (
+	"io"
+	"log"
+	"os"
+)
+
+// Logging
+// Informational messages go through the standard logger on stdout (or on
+// stderr when stdout carries data). Errors go through errLog, which writes
+// to stderr and, with -error-log, also appends to a file so headless runs
+// can alert on it.
+
+var errLog = log.New(os.Stderr, "", log.LstdFlags)
+
+// logErrorf logs an error-level message
+func logErrorf(format string, args ...any) {
+	errLog.Printf(format, args...)
+}
+
+// fatalf logs an error-level message and exits with status 1
+func fatalf(format string, args ...any) {
+	errLog.Printf(format, args...)
+	os.Exit(1)
+}
+
+// setupLogging sends info logs to stdout, or to stderr if dataOnStdout is
+// set, and if errorLogPath is set copies error logs into that file. The
+// returned func closes the file.
+func setupLogging(errorLogPath string, dataOnStdout bool) (func(), error) {
+	if dataOnStdout {
+		log.SetOutput(os.Stderr)
+	} else {
+		log.SetOutput(os.Stdout)
+	}
+	if errorLogPath == "" {
+		return func() {}, nil
+	}
+
+	file, err := os.OpenFile(errorLogPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	errLog.SetOutput(io.MultiWriter(os.Stderr, file))
+	return func() { file.Close() }, nil
+}
@@ -1,237 +1,688 @@
 package main
 
 import (
-	"encoding/csv"
-	"encoding/json"
+	"context"
 	"fmt"
-	"io"
 	"log"
-	"net/http"
 	"os"
-	"strconv"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 )
 
-// Structs to Match the JSON Response
-// These are built to match the JSON output observed from Broker Check search output.
+// Exit statuses besides 0 and 1
+const (
+	exitEmpty   = 3 // A -quiet-on-empty run with no results
+	exitTooFew  = 4 // Fewer brokers saved than -fail-under
+	exitPartial = 5 // A request failure stopped the scrape; what it got was saved
+)
 
-type BrokerResponse struct {
-	Hits HitData `json:"hits"`
-}
+func main() {
+	// Subcommands. A bare flag list (or "scrape") runs the scrape, as it
+	// always has.
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "check":
+			os.Exit(runCheck(os.Args[2:]))
+		case "merge":
+			os.Exit(runMerge(os.Args[2:]))
+		case "diff":
+			os.Exit(runDiff(os.Args[2:]))
+		case "scrape":
+			os.Args = append(os.Args[:1], os.Args[2:]...)
+		}
+	}
 
-type HitData struct {
-	Total int         `json:"total"`
-	Hits  []BrokerHit `json:"hits"`
-}
+	opts := parseOptions()
+	if opts.ListPresets || opts.ListStates {
+		if opts.ListPresets {
+			printPresets(os.Stdout)
+		}
+		if opts.ListStates {
+			printStates(os.Stdout)
+		}
+		return
+	}
 
-type BrokerHit struct {
-	Source BrokerSource `json:"_source"`
-}
+	closeLogs, err := setupLogging(opts.ErrorLog, opts.toStdout())
+	if err != nil {
+		fatalf("Error opening -error-log file: %v", err)
+	}
+	defer closeLogs()
 
-// BrokerSource contains the actual broker data
-type BrokerSource struct {
-	CRD                string       `json:"ind_source_id"`
-	FirstName          string       `json:"ind_firstname"`
-	LastName           string       `json:"ind_lastname"`
-	CurrentEmployments []Employment `json:"ind_current_employments"`
-}
+	opts.resolve()
+	if opts.ConfigDump {
+		if err := dumpConfig(os.Stdout, opts); err != nil {
+			fatalf("Error writing -config-dump: %v", err)
+		}
+		return
+	}
+
+	if opts.Pprof != "" {
+		if err := startPprof(opts.Pprof); err != nil {
+			fatalf("Error starting -pprof server: %v", err)
+		}
+	}
 
-// Employment contains the firm's details
-type Employment struct {
-	FirmName string `json:"firm_name"`
-	City     string `json:"branch_city"`
-	State    string `json:"branch_state"`
-	Zip      string `json:"branch_zip"`
+	// SIGINT (Ctrl-C) and SIGTERM (e.g. a Kubernetes eviction) both stop
+	// fetching; whatever was collected is still saved. A second signal
+	// kills the process immediately.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	var progress *progressStream
+	if opts.ProgressJSON != "" {
+		progress, err = openProgressStream(opts.ProgressJSON)
+		if err != nil {
+			fatalf("Error opening -progress-json: %v", err)
+		}
+		defer progress.Close()
+	}
+	var errStream *errorStream
+	if opts.ErrorStream != "" {
+		errStream, err = openErrorStream(opts.ErrorStream)
+		if err != nil {
+			fatalf("Error opening -error-stream file: %v", err)
+		}
+		defer errStream.Close()
+	}
+
+	var code int
+	if opts.Interval > 0 {
+		code = runInterval(ctx, opts, progress, errStream)
+	} else {
+		_, code = scrapeOnce(ctx, opts, progress, errStream)
+	}
+	if code != 0 {
+		closeLogs()
+		os.Exit(code)
+	}
 }
 
-// Global HTTP client for connection reuse
-var client = &http.Client{Timeout: 10 * time.Second}
+// scrapeOnce runs the scrape (or -input reprocessing) the flags describe,
+// saves the results and returns the files written and the exit status
+func scrapeOnce(ctx context.Context, opts *options, progress *progressStream, errStream *errorStream) ([]string, int) {
+	// Replays come from disk, so there's no server to be polite to
+	delay := max(time.Duration(float64(time.Second)/opts.RPS), time.Nanosecond)
+	if opts.ReplayDir != "" {
+		delay = time.Millisecond
+	}
+	if opts.FastPaging {
+		delay = -1 // Back-to-back requests; 429/503 backoff and retry delays still apply
+	}
 
-// API Search Parameters
-// These are from the URL found when inspecting Fetch/XHR of API from Broker Check website
-const (
-	apiURL    = "https://api.brokercheck.finra.org/search/individual"
-	latitude  = "38.895568"  // For Washington D.C. area (example)
-	longitude = "-77.026278" // For Washington D.C. area (example)
-	radius    = "25"         // 25-mile radius
-	pageSize  = 100          // Get 100 results per page (max allowed is often 100 or 50)
-)
+	region := opts.regions[0]
+	var sourceLabel string
+	if opts.AppendSource {
+		sourceLabel = region.label()
+	}
+	scraper := NewScraper(Config{
+		Latitude:  region.Lat,
+		Longitude: region.Lon,
+		Radius:    region.Radius,
+		Query:     opts.Query,
+		PageSize:  opts.Rows,
+		Delay:     delay,
+		Burst:     opts.Burst,
+
+		States:        opts.states,
+		KeepRaw:       opts.Raw,
+		FieldMap:      opts.fieldMap,
+		Verbose:       opts.Verbose,
+		OmitPrevious:  !opts.IncludePrev,
+		MaxConcurrent: opts.MaxConcurrent,
+		Workers:       opts.Workers,
+		PageBuffer:    opts.PageBuffer,
+		SourceLabel:   sourceLabel,
+		PageTimeout:   opts.PageTimeout,
+		MaxIdle:       opts.MaxIdle,
+		DumpDir:       opts.DumpDir,
+		Highlight:     opts.Highlight,
+		Retry:         RetryPolicy{Attempts: opts.Retries, Base: opts.RetryBase, Max: opts.RetryMax, Jitter: opts.RetryJitter, Statuses: opts.retryOn},
+		Preflight:     opts.Preflight,
+		WarmUp:        opts.WarmUp,
+		Ramp:          opts.Ramp,
+		MaxBandwidth:  opts.MaxBandwidth,
+		TLS:           opts.tls,
+		SourceIPs:     opts.sourceIPs,
+		MinRadius:     opts.MinRadius,
+		Referer:       opts.Referer,
+		StrictSchema:  opts.StrictSchema,
+		Origin:        opts.Origin,
+		Headers:       opts.headers,
+	})
+	scraper.ProgressFunc = func(phase string, done, total int) {
+		log.Printf("Progress (%s): %d/%d brokers", phase, done, total)
+		if progress != nil {
+			progress.Progress(phase, done, total)
+		}
+	}
 
-func main() {
-	var allBrokers []BrokerSource
-	currentPage := 0
-	totalResults := 0 // We'll get this from the first request
+	// Failures below return a status rather than exiting, so -interval can
+	// carry on with the next cycle
+	if err := setupRecording(scraper, opts.RecordDir, opts.ReplayDir); err != nil {
+		logErrorf("Error setting up recording: %v", err)
+		return nil, 1
+	}
 
-	log.Println("Starting scrape...")
+	if err := setupCache(scraper, opts.CacheDir, opts.CacheTTL); err != nil {
+		logErrorf("Error setting up -cache-ttl: %v", err)
+		return nil, 1
+	}
 
-	for {
-		// Calculate the 'start' parameter for pagination
-		start := currentPage * pageSize
+	if errStream != nil {
+		scraper.ErrorFunc = errStream.Record
+	}
+
+	if opts.CountByState {
+		countByState(ctx, scraper, "state-counts.csv")
+		return nil, 0
+	}
+
+	if len(opts.states) > 0 && opts.Input == "" {
+		checkStateFilter(ctx, scraper)
+	}
 
-		// Break the loop if we've already gathered all results
-		if totalResults > 0 && start >= totalResults {
-			break
+	if opts.DryRun {
+		dryRun(ctx, scraper, opts)
+		return nil, 0
+	}
+
+	if opts.Stream {
+		saved, err := runStream(opts)
+		if err != nil {
+			logErrorf("Stream stopped: %v; no output was saved", err)
+			return nil, 1
 		}
+		return nil, failUnder(saved, opts)
+	}
 
-		log.Printf("Fetching page %d (starting at record %d)...", currentPage+1, start)
+	// -collect-timeout and -enrich-timeout bound the two phases separately
+	collectCtx, cancelCollect := withPhaseTimeout(ctx, opts.CollectLimit)
+	defer cancelCollect()
+	if opts.NDJSON != "" {
+		return scrapeToNDJSON(ctx, collectCtx, scraper, opts, progress)
+	}
 
-		response, err := fetchBrokerData(latitude, longitude, start, pageSize)
+	var allBrokers []BrokerSource
+	var err error
+	if opts.Input != "" {
+		allBrokers, err = loadBrokers(opts.Input)
 		if err != nil {
-			log.Printf("Error fetching page %d: %v", currentPage+1, err)
-			break // Stop on error
+			logErrorf("Error loading -input: %v", err)
+			return nil, 1
+		}
+		log.Printf("Loaded %d brokers from %s", len(allBrokers), opts.Input)
+	} else if len(opts.regions) > 1 {
+		allBrokers, err = runRegions(collectCtx, scraper, opts)
+	} else if opts.checkpointing() {
+		allBrokers, err = scrapeCheckpointed(collectCtx, scraper, opts)
+	} else {
+		allBrokers, err = scrapeSearch(collectCtx, scraper, opts, "")
+	}
+	scrapeErr := err
+	enrichBudget := opts.EnrichLimit
+	if deadline, ok := collectCtx.Deadline(); ok && opts.Input == "" {
+		if collectCtx.Err() == context.DeadlineExceeded && ctx.Err() == nil {
+			logErrorf("Collect timeout: stopped after %s; keeping the %d brokers collected so far", opts.CollectLimit, len(allBrokers))
+		} else if left := time.Until(deadline); opts.Rollover && left > 0 {
+			enrichBudget += left
+			log.Printf("Collect finished with %s to spare; adding it to the enrich budget (%s)", left.Round(time.Second), enrichBudget.Round(time.Second))
+		}
+	}
+	cancelCollect()
+	if err != nil && len(allBrokers) == 0 && ctx.Err() == nil && collectCtx.Err() != context.DeadlineExceeded {
+		// Empty output files would only hide the failure from whatever
+		// reads them next
+		logErrorf("Scrape failed before any brokers were collected; nothing was saved: %v", err)
+		if hint := failureHint(err); hint != "" {
+			logErrorf("Hint: %s", hint)
 		}
+		if progress != nil {
+			progress.Done(scraper.Stats, 0, 0, time.Since(opts.started), false, err)
+		}
+		return nil, 1
+	}
+	if err != nil {
+		if cat := fetchCategory(err); cat != "" {
+			logErrorf("Scrape stopped early (%s error): %v", cat, err)
+		} else {
+			logErrorf("Scrape stopped early: %v", err)
+		}
+	}
 
-		// Set totalResults on the first loop
-		if totalResults == 0 {
-			totalResults = response.Hits.Total
-			if totalResults == 0 {
-				log.Println("API returned 0 total results. Exiting.")
-				break
+	// Before dedup, so -dedupe-by name and the filters see the cleaned names
+	if opts.NormalizeWS {
+		normalizeWhitespace(allBrokers)
+	}
+
+	var repeated []crdCount
+	if opts.DedupeReport {
+		repeated = duplicateCRDs(allBrokers)
+	}
+	log.Printf("Deduplicating results by %s...", opts.DedupeBy)
+	total := len(allBrokers)
+	allBrokers, dups := dedupe(allBrokers, opts.DedupeBy)
+	log.Printf("Scrape complete. Found %d total brokers, %d unique (%d duplicates removed).", total, len(allBrokers), dups)
+
+	allBrokers = postProcess(allBrokers, opts, log.Default())
+	if opts.counties != nil {
+		found, total := countyCoverage(allBrokers)
+		warnCountyMisses(found, total, opts.ZipCountyFile)
+	}
+
+	if opts.Detail {
+		enrichCtx, cancelEnrich := withPhaseTimeout(ctx, enrichBudget)
+		if err := scraper.EnrichDetails(enrichCtx, allBrokers); err != nil {
+			if enrichCtx.Err() == context.DeadlineExceeded && ctx.Err() == nil {
+				logErrorf("Enrich timeout: stopped after %s; brokers not reached keep no detail document", enrichBudget.Round(time.Second))
+			} else {
+				logErrorf("Detail enrichment stopped early: %v", err)
 			}
-			log.Printf("Found %d total results. Starting download...", totalResults)
 		}
+		cancelEnrich()
+	}
+	applyCharset(allBrokers, opts, log.Default())
+
+	// A clean run that found nothing writes nothing, so it can't hand
+	// downstream jobs empty files. Failed or interrupted runs still save.
+	if opts.QuietOnEmpty && len(allBrokers) == 0 && scrapeErr == nil && ctx.Err() == nil {
+		log.Printf("No brokers to save (%d collected before filtering); skipping output files (-quiet-on-empty)", total)
+		if progress != nil {
+			progress.Done(scraper.Stats, 0, 0, time.Since(opts.started), false, nil)
+		}
+		return nil, exitEmpty
+	}
 
-		// Add the brokers from this page to our main list
-		for _, hit := range response.Hits.Hits {
-			allBrokers = append(allBrokers, hit.Source)
+	if opts.Shuffle {
+		log.Printf("Shuffling output order (seed %d)...", opts.Seed)
+		opts.rng.Shuffle(len(allBrokers), func(i, j int) {
+			allBrokers[i], allBrokers[j] = allBrokers[j], allBrokers[i]
+		})
+	}
+	if opts.Index {
+		numberBrokers(allBrokers)
+	}
+	if opts.numericCRD() {
+		if err := checkNumericCRDs(allBrokers); err != nil {
+			logErrorf("-crd-type number: %v; nothing was saved (see -verify-crd-format drop)", err)
+			if progress != nil {
+				progress.Done(scraper.Stats, 0, 0, time.Since(opts.started), ctx.Err() != nil, err)
+			}
+			return nil, 1
 		}
+	}
 
-		// If this was the last page, stop
-		if len(response.Hits.Hits) < pageSize {
-			break
+	// Save the results
+	search := fmt.Sprintf("%s, %s within %s miles", scraper.Config.Latitude, scraper.Config.Longitude, scraper.Config.Radius)
+	if opts.Input != "" {
+		search = "saved scrape " + opts.Input
+	} else if opts.GridFile != "" {
+		search = fmt.Sprintf("%d grid cells from %s", len(opts.regions), opts.GridFile)
+	} else if len(opts.regions) > 1 {
+		search = "regions " + opts.Region
+	}
+	if opts.Query != "" && opts.Input == "" && scraper.Config.Latitude == "" {
+		search = fmt.Sprintf("query %q", opts.Query)
+	} else if opts.Query != "" && opts.Input == "" {
+		search = fmt.Sprintf("query %q, %s", opts.Query, search)
+	}
+	written := saveOutputs(allBrokers, opts, search)
+	if opts.DedupeReport {
+		n, err := saveDedupeReport(repeated, "dedupe-report.csv", opts.comma)
+		written.add("dedupe-report", "dedupe-report.csv", n, err)
+	}
+	var files []string
+	for _, f := range written.files() {
+		files = append(files, f.Path)
+	}
+	if opts.Manifest {
+		if saveManifest(written.files(), runParameters(scraper.Config), "manifest.json") == nil {
+			files = append(files, "manifest.json")
+		}
+	}
+	if opts.Tar != "" {
+		if err := writeTar(os.Stdout, files, opts.Tar == "tgz"); err != nil {
+			logErrorf("Error writing -tar archive: %v", err)
+		} else {
+			log.Printf("Wrote %d files to stdout as a %s archive", len(files), opts.Tar)
+		}
+	}
+	if opts.StateZip != "" {
+		if err := saveZip(files, opts.StateZip); err != nil {
+			logErrorf("Error writing -state-zip archive: %v", err)
+		} else {
+			log.Printf("Successfully saved %d files to %s", len(files), opts.StateZip)
+			files = append(files, opts.StateZip)
 		}
+	}
+	if opts.checkpointing() && scrapeErr == nil && ctx.Err() == nil {
+		removeCheckpoint()
+	}
 
-		currentPage++
-		time.Sleep(1 * time.Second) // Be polite! Let's not break the website
+	st := scraper.Stats
+	if opts.Input != "" {
+		log.Printf("Summary: loaded %d records from %s; saved %d brokers", total, opts.Input, len(allBrokers))
+	} else {
+		log.Printf("Summary: API reported %d; attempted %d pages (%d requests), fetched %d pages; received %d records; saved %d brokers",
+			st.Reported, st.PagesAttempted, st.Requests, st.PagesFetched, st.RecordsFetched, len(allBrokers))
 	}
 
-	log.Println("Deduplicating results...")
+	if progress != nil {
+		progress.Done(st, len(allBrokers), len(files), time.Since(opts.started), ctx.Err() != nil, scrapeErr)
+	}
 
-	// Use a map to store unique brokers. The key is the CRD.
-	// The value is the broker object itself.
-	uniqueBrokers := make(map[string]BrokerSource)
+	if opts.MaxBandwidth > 0 && opts.Input == "" {
+		log.Printf("Bandwidth: %s, capped at %s/s", scraper.bandwidth.Summary(), formatBytes(opts.MaxBandwidth))
+	}
 
-	for _, broker := range allBrokers {
-		// This will automatically overwrite any duplicate CRDs
-		uniqueBrokers[broker.CRD] = broker
+	if ctx.Err() != nil {
+		logErrorf("Scrape was interrupted; saved the %d brokers collected before the signal", len(allBrokers))
+		return files, 1
 	}
 
-	// Now, convert the map back into a slice
-	finalBrokerList := make([]BrokerSource, 0, len(uniqueBrokers))
-	for _, broker := range uniqueBrokers {
-		finalBrokerList = append(finalBrokerList, broker)
+	if opts.Head > 0 {
+		// Keep stdout pure data when it's carrying the output
+		headOut := os.Stdout
+		if opts.toStdout() {
+			headOut = os.Stderr
+		}
+		printHead(headOut, allBrokers, opts.Head)
 	}
 
-	log.Printf("Scrape complete. Found %d total brokers, %d unique.", len(allBrokers), len(finalBrokerList))
+	if failed := written.failed(); len(failed) > 0 {
+		logErrorf("Some outputs failed to save (%s); see the errors above", strings.Join(failed, ", "))
+		return files, 1
+	}
 
-	// Save the results
-	saveToJSON(allBrokers, "brokers.json")
-	saveToCSV(allBrokers, "brokers.csv")
+	if partialScrape(scrapeErr, collectCtx) {
+		logErrorf("Scrape stopped early on an error; saved the %d brokers collected before it", len(allBrokers))
+		return files, exitPartial
+	}
+	return files, failUnder(len(allBrokers), opts)
 }
 
-// fetchBrokerData performs the GET request to the API
-func fetchBrokerData(lat, lon string, start, rows int) (*BrokerResponse, error) {
-	// Create a new GET request
-	req, err := http.NewRequest("GET", apiURL, nil)
-	if err != nil {
-		return nil, err
-	}
-
-	// Build the Query Parameters
-	q := req.URL.Query()
-	q.Set("lat", lat)
-	q.Set("lon", lon)
-	q.Set("includePrevious", "true")
-	q.Set("hl", "true")
-	q.Set("nrows", strconv.Itoa(rows))
-	q.Set("start", strconv.Itoa(start))
-	q.Set("r", radius)
-	q.Set("sort", "score+desc")
-	q.Set("wt", "json")
-	req.URL.RawQuery = q.Encode()
-
-	// Set Headers
-	// Mimic the browser headers. User-Agent is often the most important.
-	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/91.0.4472.124 Safari/537.36")
-	req.Header.Set("Accept", "application/json")
-
-	// Perform the request
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
+// partialScrape reports whether err cut the search short, rather than the
+// -collect-timeout budget running out
+func partialScrape(err error, collectCtx context.Context) bool {
+	return err != nil && collectCtx.Err() != context.DeadlineExceeded
+}
+
+// failUnder returns exitTooFew if fewer than -fail-under brokers were
+// saved, and 0 otherwise
+func failUnder(saved int, opts *options) int {
+	if opts.FailUnder <= 0 {
+		return 0
+	}
+	if saved < opts.FailUnder {
+		logErrorf("Fail-under: saved %d brokers, below the -fail-under threshold of %d; the source may be broken", saved, opts.FailUnder)
+		return exitTooFew
 	}
-	defer resp.Body.Close()
+	log.Printf("Fail-under: saved %d brokers, at or above the threshold of %d", saved, opts.FailUnder)
+	return 0
+}
 
-	if resp.StatusCode != 200 {
-		return nil, fmt.Errorf("bad status code: %d for URL: %s", resp.StatusCode, req.URL.String())
+// scrapeSearch runs one search the way the flags ask: split into cells
+// past the pagination cap with -auto-subdivide, into two sort directions
+// with -sort-reversal, and written page by page with -per-page-output.
+// prefix names the search in per-page filenames.
+func scrapeSearch(ctx context.Context, s *Scraper, opts *options, prefix string) ([]BrokerSource, error) {
+	search := func(sub *Scraper, name string) ([]BrokerSource, error) {
+		if !opts.SortReversal {
+			return collectPages(ctx, sub, opts.PerPageDir, name)
+		}
+		return sub.RunReversed(ctx, func(pass *Scraper, dir string) ([]BrokerSource, error) {
+			return collectPages(ctx, pass, opts.PerPageDir, joinPrefix(name, dir))
+		})
 	}
+	if !opts.AutoSubdivide {
+		return search(s, prefix)
+	}
+	return s.RunSubdivided(ctx, func(sub *Scraper, cell int) ([]BrokerSource, error) {
+		return search(sub, joinPrefix(prefix, fmt.Sprintf("cell%03d", cell)))
+	})
+}
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
+// withPhaseTimeout derives a context that expires after d, or one with no
+// deadline of its own when d is 0
+func withPhaseTimeout(ctx context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	if d <= 0 {
+		return context.WithCancel(ctx)
 	}
+	return context.WithTimeout(ctx, d)
+}
 
-	// Unmarshal the JSON into our structs
-	var brokerResponse BrokerResponse
-	if err := json.Unmarshal(body, &brokerResponse); err != nil {
-		return nil, fmt.Errorf("error unmarshaling JSON: %v. Body: %s", err, string(body))
+// joinPrefix appends name to a per-page filename prefix
+func joinPrefix(prefix, name string) string {
+	if prefix == "" || name == "" {
+		return prefix + name
 	}
+	return prefix + "-" + name
+}
 
-	return &brokerResponse, nil
+// dryRun reports how many results and pages each search would take,
+// without downloading any brokers
+func dryRun(ctx context.Context, s *Scraper, opts *options) {
+	for _, r := range opts.regions {
+		total, err := s.TotalAt(ctx, r.Lat, r.Lon, r.Radius)
+		if err != nil {
+			logErrorf("Dry run: counting %s: %v", r.label(), err)
+			continue
+		}
+		pages := (total + s.Config.PageSize - 1) / s.Config.PageSize
+		log.Printf("Dry run: %s within %s miles: %d results in %d pages of %d", r.label(), r.Radius, total, pages, s.Config.PageSize)
+		if total > paginationCap && !opts.AutoSubdivide {
+			log.Printf("Warning: only the first %d of those can be paged through; add -auto-subdivide to get the rest", paginationCap)
+		}
+	}
 }
 
-// Utility Functions for Saving
+// postProcess applies the filters and clean-up steps selected on the
+// command line. Filters run before detail enrichment so we don't fetch
+// detail documents for brokers that would be dropped anyway. Each step's
+// summary goes to logger.
+func postProcess(brokers []BrokerSource, opts *options, logger *log.Logger) []BrokerSource {
+	if opts.YearsExp {
+		addYearsExperience(brokers, time.Now())
+	}
 
-func saveToJSON(data []BrokerSource, filename string) {
-	file, err := json.MarshalIndent(data, "", "  ")
-	if err != nil {
-		log.Printf("Error marshaling JSON: %v", err)
-		return
+	if opts.StripHighlight {
+		n := stripHighlights(brokers)
+		if n > 0 {
+			deriveFields(brokers, time.Now()) // The profile URL is built from the CRD
+		}
+		logger.Printf("Strip highlight: removed markup from %d fields", n)
 	}
-	err = os.WriteFile(filename, file, 0644)
-	if err != nil {
-		log.Printf("Error writing JSON file: %v", err)
+
+	if opts.WarnEmpty > 0 {
+		warnEmptyFields(brokers, opts.WarnEmpty)
 	}
-	log.Printf("Successfully saved to %s", filename)
-}
 
-func saveToCSV(data []BrokerSource, filename string) {
-	file, err := os.Create(filename)
-	if err != nil {
-		log.Printf("Error creating CSV file: %v", err)
-		return
+	if opts.GeoCheck > 0 {
+		checkGeography(brokers, opts.regions, opts.GeoCheck)
+	}
+
+	if opts.VerifyCRD != "" {
+		before := len(brokers)
+		var malformed int
+		brokers, malformed = verifyCRDs(brokers, opts.VerifyCRD == crdCheckDrop)
+		logger.Printf("CRD format check: %d of %d brokers had a malformed CRD (%d dropped)", malformed, before, before-len(brokers))
 	}
-	defer file.Close()
 
-	writer := csv.NewWriter(file)
-	defer writer.Flush()
+	if opts.DropTest {
+		before := len(brokers)
+		brokers = dropTestRecords(brokers, opts.testPattern)
+		logger.Printf("Drop test records: dropped %d of %d brokers", before-len(brokers), before)
+	}
 
-	// Write Header
-	// We flatten the data: get the first current employment for the CSV
-	writer.Write([]string{"CRD", "FirstName", "LastName", "FirmName", "FirmCity", "FirmState", "FirmZip"})
+	if !opts.sinceCutoff.IsZero() {
+		before := len(brokers)
+		var undated int
+		brokers, undated = filterRegisteredSince(brokers, opts.sinceCutoff, opts.KeepUndated)
+		logger.Printf("Registered since %s: kept %d of %d brokers (%d had no usable start date)",
+			opts.sinceCutoff.Format("2006-01-02"), len(brokers), before, undated)
+	}
 
-	// Write Data Rows
-	for _, broker := range data {
-		var firmName, city, state, zip string
+	if len(opts.states) > 0 {
+		before := len(brokers)
+		brokers = Results(brokers).FilterByState(opts.states...)
+		logger.Printf("Only states %s: kept %d of %d brokers", strings.Join(opts.states, ","), len(brokers), before)
+	}
 
-		// Safely get the first employment record
-		if len(broker.CurrentEmployments) > 0 {
-			firmName = broker.CurrentEmployments[0].FirmName
-			city = broker.CurrentEmployments[0].City
-			state = broker.CurrentEmployments[0].State
-			zip = broker.CurrentEmployments[0].Zip
-		}
+	if opts.MinFirms > 0 {
+		before := len(brokers)
+		brokers = filterMinFirms(brokers, opts.MinFirms)
+		logger.Printf("Min firms %d: kept %d of %d brokers (%d filtered)", opts.MinFirms, len(brokers), before, before-len(brokers))
+	}
 
-		row := []string{
-			broker.CRD,
-			broker.FirstName,
-			broker.LastName,
-			firmName,
-			city,
-			state,
-			zip,
+	if opts.OnlyActive {
+		before := len(brokers)
+		brokers = filterMinFirms(brokers, 1)
+		logger.Printf("Only active: kept %d of %d brokers (%d had no current employment)", len(brokers), before, before-len(brokers))
+	}
+
+	if opts.HasPrevious {
+		before := len(brokers)
+		brokers = filterHasPrevious(brokers)
+		logger.Printf("Has previous: kept %d of %d brokers (%d had no previous employment)", len(brokers), before, before-len(brokers))
+	}
+
+	if opts.filter != nil {
+		before := len(brokers)
+		var errs []error
+		brokers, errs = filterByExpr(brokers, opts.filter)
+		for _, err := range errs {
+			logErrorf("Filter %q failed, dropping the broker: %v", opts.Filter, err)
 		}
-		writer.Write(row)
+		logger.Printf("Filter %q: kept %d of %d brokers (%d dropped on an error)", opts.Filter, len(brokers), before, len(errs))
+	}
+
+	if opts.watchlist != nil {
+		before := len(brokers)
+		brokers = matchWatchlist(brokers, opts.watchlist, opts.MatchThreshold)
+		logger.Printf("Watchlist: %d of %d brokers matched one of %d names (threshold %g)", len(brokers), before, len(opts.watchlist), opts.MatchThreshold)
+	}
+
+	if opts.NormalizeZip {
+		n := normalizeZips(brokers, opts.ZipPlus4)
+		logger.Printf("Normalize ZIP: rewrote %d branch ZIP codes", n)
+	}
+
+	if opts.counties != nil {
+		found, total := addCounties(brokers, opts.counties)
+		logger.Printf("ZIP county: found the county of %d of %d branch ZIPs (%d ZIPs in the table)", found, total, len(opts.counties))
+	}
+
+	if opts.StateNames {
+		named, total := addStateNames(brokers)
+		logger.Printf("State names: named the state of %d of %d employments", named, total)
+	}
+
+	if opts.firmMap != nil {
+		n := canonicalizeFirms(brokers, opts.firmMap)
+		logger.Printf("Firm map: canonicalized %d firm names", n)
+	}
+
+	if opts.CompactEmps {
+		n := compactEmployments(brokers)
+		logger.Printf("Compact employments: removed %d repeated firm entries", n)
+	}
+
+	if opts.MaxEmployments > 0 {
+		n := capEmployments(brokers, opts.MaxEmployments)
+		logger.Printf("Max employments %d: removed %d employment entries (num_current_firms keeps the full count)", opts.MaxEmployments, n)
+	}
+
+	if opts.LimitPerFirm > 0 {
+		before := len(brokers)
+		brokers = limitPerFirm(brokers, opts.LimitPerFirm)
+		logger.Printf("Limit per firm %d: kept %d of %d brokers", opts.LimitPerFirm, len(brokers), before)
+	}
+	return brokers
+}
+
+// saveOutputs writes every format requested with -format (and the extra
+// summaries) and returns every save it did, failed ones included
+func saveOutputs(brokers []BrokerSource, opts *options, search string) outputFiles {
+	var written outputFiles
+	if opts.GroupByStateDir != "" {
+		written = saveByState(brokers, opts, search)
+	} else {
+		written = saveFormats(brokers, opts, search, opts.outputPath)
+	}
+	if opts.formats["postgres"] {
+		n, err := saveToPostgres(brokers, opts.DSN)
+		written.add("postgres", "", n, err)
+	}
+
+	if opts.Schema {
+		n, err := saveSchema("schema.json", opts.csvOptions())
+		written.add("schema", "schema.json", n, err)
+	}
+	if opts.Raw {
+		n, err := saveRawNDJSON(brokers, "raw-brokers.ndjson")
+		written.add("raw-ndjson", "raw-brokers.ndjson", n, err)
+	}
+	if opts.GroupByFirm {
+		n, err := saveFirmSummary(brokers, "firms-summary.csv", opts.comma)
+		written.add("firms-summary", "firms-summary.csv", n, err)
+	}
+	if opts.FirmsOnly {
+		n, err := saveFirmLocations(brokers, "firm-locations.csv", opts.comma)
+		written.add("firm-locations", "firm-locations.csv", n, err)
+	}
+	return written
+}
+
+// saveFormats writes brokers in every file -format, naming each file with
+// path. The postgres format isn't a file and is saved by the caller.
+func saveFormats(brokers []BrokerSource, opts *options, search string, path func(format string, count int) string) outputFiles {
+	var written outputFiles
+
+	if opts.formats["json"] {
+		p := path("json", len(brokers))
+		n, err := saveToJSON(brokers, p, opts.numericCRD())
+		written.add("json", p, n, err)
+	}
+	if opts.formats["ndjson"] {
+		written = append(written, saveShards("ndjson", brokers, opts.ShardSize, path, func(part []BrokerSource, p string) (int, error) {
+			return saveToNDJSON(part, p, opts.numericCRD())
+		})...)
+	}
+	if opts.formats["csv"] {
+		written = append(written, saveShards("csv", brokers, opts.ShardSize, path, func(part []BrokerSource, p string) (int, error) {
+			return saveToCSV(part, p, opts.csvOptions())
+		})...)
+	}
+	if opts.formats["geojson"] {
+		p := path("geojson", len(brokers))
+		n, err := saveToGeoJSON(brokers, p, opts.zipCoords)
+		written.add("geojson", p, n, err)
+	}
+	if opts.formats["html"] {
+		p := path("html", len(brokers))
+		n, err := saveToHTML(brokers, p, search)
+		written.add("html", p, n, err)
+	}
+	if opts.formats["crds"] {
+		p := path("crds", len(brokers))
+		n, err := saveCRDList(brokers, p)
+		written.add("crds", p, n, err)
+	}
+	if opts.formats["firm-counts"] {
+		p := path("firm-counts", len(brokers))
+		n, err := saveFirmCounts(brokers, p)
+		written.add("firm-counts", p, n, err)
+	}
+	if opts.formats["avro"] {
+		p := path("avro", len(brokers))
+		n, err := saveToAvro(brokers, p)
+		written.add("avro", p, n, err)
 	}
-	log.Printf("Successfully saved to %s", filename)
+	return written
 }
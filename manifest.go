@@ -0,0 +1,140 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"io"
+	"log"
+	"os"
+	"time"
+)
+
+// Output Manifest
+// manifest.json lists every file a run wrote with its size, record count
+// and SHA-256, plus the parameters of the run, so consumers can verify the
+// files arrived intact and know how they were produced.
+
+// outputFile is one save done by saveOutputs. Path is empty for outputs
+// that aren't files (postgres), and Err is set if the save failed.
+type outputFile struct {
+	Path    string
+	Format  string
+	Records int
+	Err     error
+}
+
+// outputFiles collects the saves a save step did
+type outputFiles []outputFile
+
+// add records a save unless it went to stdout and succeeded
+func (f *outputFiles) add(format, path string, records int, err error) {
+	if err != nil || path != stdoutName {
+		*f = append(*f, outputFile{Path: path, Format: format, Records: records, Err: err})
+	}
+}
+
+// files returns the files that were written successfully
+func (f outputFiles) files() []outputFile {
+	var ok []outputFile
+	for _, o := range f {
+		if o.Err == nil && o.Path != "" {
+			ok = append(ok, o)
+		}
+	}
+	return ok
+}
+
+// failed returns the formats whose save failed
+func (f outputFiles) failed() []string {
+	var bad []string
+	for _, o := range f {
+		if o.Err != nil {
+			bad = append(bad, o.Format)
+		}
+	}
+	return bad
+}
+
+type manifest struct {
+	Generated  string            `json:"generated"`
+	Parameters map[string]string `json:"parameters"`
+	Files      []manifestFile    `json:"files"`
+}
+
+type manifestFile struct {
+	Path    string `json:"path"`
+	Format  string `json:"format"`
+	Bytes   int64  `json:"bytes"`
+	Records int    `json:"records"`
+	SHA256  string `json:"sha256"`
+}
+
+// runParameters returns every flag's effective value plus the search the
+// scraper ran
+func runParameters(cfg Config) map[string]string {
+	params := make(map[string]string)
+	flag.VisitAll(func(f *flag.Flag) {
+		params[f.Name] = redactFlag(f.Name, f.Value.String())
+	})
+	params["search.lat"] = cfg.Latitude
+	params["search.lon"] = cfg.Longitude
+	params["search.radius"] = cfg.Radius
+	return params
+}
+
+// fileChecksum returns the size and hex SHA-256 of a file
+func fileChecksum(path string) (int64, string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, "", err
+	}
+	defer file.Close()
+
+	h := sha256.New()
+	n, err := io.Copy(h, file)
+	if err != nil {
+		return 0, "", err
+	}
+	return n, hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// buildManifest checksums the written files. A file that can't be read is
+// logged and left out.
+func buildManifest(files []outputFile, params map[string]string) manifest {
+	m := manifest{
+		Generated:  time.Now().UTC().Format(time.RFC3339),
+		Parameters: params,
+		Files:      []manifestFile{},
+	}
+	for _, f := range files {
+		size, sum, err := fileChecksum(f.Path)
+		if err != nil {
+			logErrorf("Error checksumming %s for the manifest: %v", f.Path, err)
+			continue
+		}
+		m.Files = append(m.Files, manifestFile{
+			Path:    f.Path,
+			Format:  f.Format,
+			Bytes:   size,
+			Records: f.Records,
+			SHA256:  sum,
+		})
+	}
+	return m
+}
+
+func saveManifest(files []outputFile, params map[string]string, filename string) error {
+	data, err := json.MarshalIndent(buildManifest(files, params), "", "  ")
+	if err != nil {
+		logErrorf("Error marshaling manifest: %v", err)
+		return err
+	}
+	if err := writeFileAtomic(filename, append(data, '\n')); err != nil {
+		logErrorf("Error writing manifest: %v", err)
+		return err
+	}
+	log.Printf("Successfully saved to %s", filename)
+	return nil
+}
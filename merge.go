@@ -0,0 +1,86 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// Merging Saved Scrapes
+// `brokercheck-scraper merge a.json b.ndjson ...` combines the outputs of
+// separate runs (e.g. one per region) into one deduplicated set, written
+// through the same savers as a scrape.
+
+// runMerge implements the merge subcommand and returns the exit code
+func runMerge(args []string) int {
+	fs := flag.NewFlagSet("merge", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s merge [flags] file1.json file2.ndjson ...\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	formatList := fs.String("format", "json", "Comma-separated output formats: "+strings.Join(supportedFormats, ", "))
+	out := fs.String("out", "", `Path for the json or ndjson output; "-" writes it to stdout`)
+	dsn := fs.String("dsn", "", "PostgreSQL connection string for -format postgres")
+	dedupeBy := fs.String("dedupe-by", dedupeByCRD, "Key for dropping duplicate brokers: crd, name (first+last+firm) or none")
+	fs.Parse(args)
+	if err := applyEnv(fs); err != nil {
+		log.Printf("merge: invalid environment setting: %v", err)
+		return 1
+	}
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 1
+	}
+	formats, err := parseFormats(*formatList)
+	if err != nil {
+		log.Printf("merge: invalid -format: %v", err)
+		return 1
+	}
+	if *out != "" && formats["json"] == formats["ndjson"] {
+		log.Printf("merge: -out names a single file; choose exactly one of json or ndjson in -format")
+		return 1
+	}
+	if formats["postgres"] != (*dsn != "") {
+		log.Printf("merge: -format postgres and -dsn go together; give both or neither")
+		return 1
+	}
+	switch *dedupeBy {
+	case dedupeByCRD, dedupeByName, dedupeByNone:
+	default:
+		log.Printf("merge: invalid -dedupe-by %q: use crd, name or none", *dedupeBy)
+		return 1
+	}
+	if *out == stdoutName {
+		log.SetOutput(os.Stderr)
+	}
+
+	var all []BrokerSource
+	for _, file := range fs.Args() {
+		brokers, err := loadBrokers(file)
+		if err != nil {
+			logErrorf("merge: %v", err)
+			return 1
+		}
+		log.Printf("Loaded %d brokers from %s", len(brokers), file)
+		all = append(all, brokers...)
+	}
+	total := len(all)
+	all, dups := dedupe(all, *dedupeBy)
+	log.Printf("Merged %d files: %d brokers, %d unique (%d duplicates removed)", fs.NArg(), total, len(all), dups)
+
+	// Only the settings the savers read; everything else keeps its zero value
+	opts := &options{Out: *out, FloatPrec: 1, formats: formats, comma: ','}
+	search := "merge of " + strings.Join(fs.Args(), ", ")
+	written := saveFormats(all, opts, search, opts.outputPath)
+	if formats["postgres"] {
+		n, err := saveToPostgres(all, *dsn)
+		written.add("postgres", "", n, err)
+	}
+	if len(written.failed()) > 0 {
+		return 1
+	}
+	return 0
+}
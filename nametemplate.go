@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Filename Templates
+// -name-template names the -format outputs from a pattern such as
+// brokers_{region}_{date}_{count}.csv, for self-describing archives.
+
+// namePlaceholder matches one {placeholder} in a -name-template
+var namePlaceholder = regexp.MustCompile(`\{[^{}]*\}`)
+
+// namePlaceholders are the placeholders a -name-template may use
+var namePlaceholders = []string{"{region}", "{date}", "{format}", "{count}"}
+
+// checkNameTemplate rejects unknown placeholders, and templates that would
+// give every format the same name
+func checkNameTemplate(tmpl string, formats int) error {
+	for _, p := range namePlaceholder.FindAllString(tmpl, -1) {
+		if !contains(namePlaceholders, p) {
+			return fmt.Errorf("unknown placeholder %s (use %s)", p, strings.Join(namePlaceholders, ", "))
+		}
+	}
+	if formats > 1 && !strings.Contains(tmpl, "{format}") {
+		return fmt.Errorf("with several -format values the template needs {format} to tell the files apart")
+	}
+	return nil
+}
+
+// expandNameTemplate fills in a checked template
+func expandNameTemplate(tmpl, region, format string, date time.Time, count int) string {
+	return strings.NewReplacer(
+		"{region}", region,
+		"{date}", date.Format("2006-01-02"),
+		"{format}", format,
+		"{count}", strconv.Itoa(count),
+	).Replace(tmpl)
+}
+
+// regionSlug is {region}: the preset names joined with "+", or lat_lon for
+// a search by coordinates
+func regionSlug(regions []searchRegion) string {
+	var names []string
+	for _, r := range regions {
+		if r.Name == "" && r.Lat == "" {
+			names = append(names, "query")
+			continue
+		}
+		if r.Name == "" {
+			names = append(names, r.Lat+"_"+r.Lon)
+			continue
+		}
+		names = append(names, r.Name)
+	}
+	return strings.Join(names, "+")
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"time"
+)
+
+// Streaming Scrapes
+// -ndjson writes brokers to an NDJSON file as their pages arrive instead of
+// gathering the whole scrape for the outputs at the end, so memory stays
+// flat however large the search. Each page is deduplicated against every
+// key seen so far and run through postProcess like a -stream batch, then
+// flushed before the next is handed over; a scrape that fails or is
+// interrupted leaves everything written up to then. Only the dedup keys
+// are kept, and steps that need every broker at once aren't available.
+
+// ndjsonUnsupported names the options -ndjson can't honour, given as the
+// flag that sets each
+func ndjsonUnsupported(o *options) []string {
+	var bad []string
+	for _, opt := range []struct {
+		name string
+		set  bool
+	}{
+		{"several regions or -grid-file", len(o.regions) > 1},
+		{"-sort-reversal", o.SortReversal},
+		{"-detail", o.Detail},
+		{"-per-page-output", o.PerPageDir != ""},
+		{"-checkpoint-every or -resume", o.checkpointing()},
+		{"-out", o.Out != ""},
+		{"-quiet-on-empty", o.QuietOnEmpty},
+		{"-format", isFlagSet("format")},
+	} {
+		if opt.set {
+			bad = append(bad, opt.name)
+		}
+	}
+	return append(bad, batchUnsupported(o)...)
+}
+
+// ndjsonSink writes pages of brokers to a file as they arrive
+type ndjsonSink struct {
+	file     *os.File
+	buf      *bufio.Writer // nil when unbuffered
+	enc      *json.Encoder
+	filter   *batchFilter
+	received int
+	numeric  bool
+}
+
+func newNDJSONSink(filename string, opts *options) (*ndjsonSink, error) {
+	file, err := os.Create(filename)
+	if err != nil {
+		return nil, err
+	}
+	k := &ndjsonSink{file: file, enc: json.NewEncoder(file), filter: newBatchFilter(opts), numeric: opts.numericCRD()}
+	if k.buf = newOutputBuffer(file); k.buf != nil {
+		k.enc = json.NewEncoder(k.buf)
+	}
+	return k, nil
+}
+
+// Write filters page and appends what's left, flushed to the file before
+// it returns
+func (k *ndjsonSink) Write(page []BrokerSource) error {
+	k.received += len(page)
+	kept, err := k.filter.filter(page)
+	if err != nil {
+		return err
+	}
+	for i := range kept {
+		if err := k.enc.Encode(jsonRecord(&kept[i], k.numeric)); err != nil {
+			return err
+		}
+	}
+	return k.flush()
+}
+
+// flush writes out what the buffer holds, if there is one
+func (k *ndjsonSink) flush() error {
+	if k.buf == nil {
+		return nil
+	}
+	return k.buf.Flush()
+}
+
+func (k *ndjsonSink) Close() error {
+	err := k.flush()
+	if cerr := k.file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// scrapeToNDJSON runs the search into opts.NDJSON (see above) and returns
+// the files written and the exit status, like scrapeOnce
+func scrapeToNDJSON(ctx, collectCtx context.Context, s *Scraper, opts *options, progress *progressStream) ([]string, int) {
+	sink, err := newNDJSONSink(opts.NDJSON, opts)
+	if err != nil {
+		logErrorf("Error creating -ndjson file: %v", err)
+		return nil, 1
+	}
+	stream := func(sub *Scraper) error {
+		return sub.Stream(collectCtx, sink.Write)
+	}
+	if opts.AutoSubdivide {
+		_, err = s.RunSubdivided(collectCtx, func(sub *Scraper, cell int) ([]BrokerSource, error) {
+			return nil, stream(sub)
+		})
+	} else {
+		err = stream(s)
+	}
+	sink.filter.finish()
+	if cerr := sink.Close(); cerr != nil {
+		logErrorf("Error writing %s: %v", opts.NDJSON, cerr)
+		if err == nil {
+			err = cerr
+		}
+	}
+
+	scrapeErr := err
+	if collectCtx.Err() == context.DeadlineExceeded && ctx.Err() == nil {
+		logErrorf("Collect timeout: stopped after %s; keeping the %d brokers written so far", opts.CollectLimit, sink.filter.saved)
+	} else if err != nil {
+		if cat := fetchCategory(err); cat != "" {
+			logErrorf("Scrape stopped early (%s error): %v", cat, err)
+		} else {
+			logErrorf("Scrape stopped early: %v", err)
+		}
+		if sink.received == 0 && ctx.Err() == nil {
+			if hint := failureHint(err); hint != "" {
+				logErrorf("Hint: %s", hint)
+			}
+		}
+	}
+
+	st := s.Stats
+	saved := sink.filter.saved
+	log.Printf("Successfully saved %d brokers to %s (%d duplicates removed as they streamed in)", saved, opts.NDJSON, sink.filter.dups)
+	log.Printf("Summary: API reported %d; attempted %d pages (%d requests), fetched %d pages; received %d records; saved %d brokers",
+		st.Reported, st.PagesAttempted, st.Requests, st.PagesFetched, st.RecordsFetched, saved)
+	if progress != nil {
+		progress.Done(st, saved, 1, time.Since(opts.started), ctx.Err() != nil, scrapeErr)
+	}
+
+	files := []string{opts.NDJSON}
+	switch {
+	case ctx.Err() != nil:
+		logErrorf("Scrape was interrupted; %s holds the %d brokers written before the signal", opts.NDJSON, saved)
+		return files, 1
+	case scrapeErr != nil && sink.received == 0 && collectCtx.Err() != context.DeadlineExceeded:
+		logErrorf("Scrape failed before any brokers were collected: %s is empty", opts.NDJSON)
+		return files, 1
+	case partialScrape(scrapeErr, collectCtx):
+		logErrorf("Scrape stopped early on an error; %s holds the %d brokers written before it", opts.NDJSON, saved)
+		return files, exitPartial
+	}
+	return files, failUnder(saved, opts)
+}
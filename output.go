@@ -0,0 +1,439 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"unicode/utf8"
+)
+
+// Utility Functions for Saving
+
+// stdoutName is the output filename that means "write to stdout"
+const stdoutName = "-"
+
+// createOutput opens filename for writing (see atomicFile), or returns
+// stdout for "-"
+func createOutput(filename string) (output, error) {
+	if filename == stdoutName {
+		if buf := newOutputBuffer(os.Stdout); buf != nil {
+			return stdoutOutput{buf}, nil
+		}
+		return nopCloser{os.Stdout}, nil
+	}
+	return createAtomic(filename)
+}
+
+// nopCloser keeps stdout open when an output is "closed"
+type nopCloser struct{ io.Writer }
+
+func (nopCloser) Close() error  { return nil }
+func (nopCloser) Commit() error { return nil }
+
+// stdoutOutput is buffered stdout. What was written can't be taken back,
+// so Close flushes it too.
+type stdoutOutput struct{ *bufio.Writer }
+
+func (s stdoutOutput) Close() error  { return s.Flush() }
+func (s stdoutOutput) Commit() error { return s.Flush() }
+
+// describeOutput is how saved files are named in log messages
+func describeOutput(filename string) string {
+	if filename == stdoutName {
+		return "stdout"
+	}
+	return filename
+}
+
+// saveToJSON writes brokers as an indented JSON array. With numericCRD,
+// CRDs are written as JSON numbers (see jsonRecord).
+func saveToJSON(data []BrokerSource, filename string, numericCRD bool) (int, error) {
+	out, err := createOutput(filename)
+	if err != nil {
+		logErrorf("Error creating JSON file: %v", err)
+		return 0, err
+	}
+	defer out.Close()
+	arr := newJSONArrayWriter(out)
+	for i := range data {
+		if err := arr.Write(jsonRecord(&data[i], numericCRD)); err != nil {
+			logErrorf("Error writing JSON file: %v", err)
+			return 0, err
+		}
+	}
+	if err := arr.Close(); err != nil {
+		logErrorf("Error writing JSON file: %v", err)
+		return 0, err
+	}
+	if err := out.Commit(); err != nil {
+		logErrorf("Error writing JSON file: %v", err)
+		return 0, err
+	}
+	log.Printf("Successfully saved to %s", describeOutput(filename))
+	return len(data), nil
+}
+
+// saveToNDJSON writes one compact JSON object per broker per line
+func saveToNDJSON(data []BrokerSource, filename string, numericCRD bool) (int, error) {
+	out, err := createOutput(filename)
+	if err != nil {
+		logErrorf("Error creating NDJSON file: %v", err)
+		return 0, err
+	}
+	defer out.Close()
+
+	enc := json.NewEncoder(out)
+	for i := range data {
+		if err := enc.Encode(jsonRecord(&data[i], numericCRD)); err != nil {
+			logErrorf("Error writing NDJSON file: %v", err)
+			return 0, err
+		}
+	}
+	if err := out.Commit(); err != nil {
+		logErrorf("Error writing NDJSON file: %v", err)
+		return 0, err
+	}
+	log.Printf("Successfully saved to %s", describeOutput(filename))
+	return len(data), nil
+}
+
+// brokerFields is BrokerSource without any methods, for embedding
+type brokerFields BrokerSource
+
+// numericCRDBroker marshals like BrokerSource but with ind_source_id as a
+// number; the outer field shadows the embedded one
+type numericCRDBroker struct {
+	CRD json.Number `json:"ind_source_id"`
+	*brokerFields
+}
+
+// jsonRecord is what the JSON formats write for b: b itself, or with
+// numericCRD, b with its CRD as a number. The CRD must already have passed
+// checkNumericCRDs; leading zeros are dropped, as a JSON number requires.
+func jsonRecord(b *BrokerSource, numericCRD bool) any {
+	if !numericCRD {
+		return b
+	}
+	crd := strings.TrimLeft(b.CRD, "0")
+	if crd == "" {
+		crd = "0"
+	}
+	return numericCRDBroker{CRD: json.Number(crd), brokerFields: (*brokerFields)(b)}
+}
+
+// checkNumericCRDs reports the brokers whose CRD can't be written as a
+// number, naming the first
+func checkNumericCRDs(brokers []BrokerSource) error {
+	bad := 0
+	first := -1
+	for i, b := range brokers {
+		if !crdPattern.MatchString(b.CRD) {
+			if bad == 0 {
+				first = i
+			}
+			bad++
+		}
+	}
+	if bad > 0 {
+		b := brokers[first]
+		return fmt.Errorf("%d brokers have a non-numeric CRD, e.g. %q for %s %s", bad, b.CRD, b.FirstName, b.LastName)
+	}
+	return nil
+}
+
+// saveRawNDJSON writes each broker's untouched API _source object, one per
+// line. Brokers without a raw object (scraped without -raw) are skipped.
+func saveRawNDJSON(data []BrokerSource, filename string) (int, error) {
+	out, err := createOutput(filename)
+	if err != nil {
+		logErrorf("Error creating raw NDJSON file: %v", err)
+		return 0, err
+	}
+	defer out.Close()
+
+	written := 0
+	var buf bytes.Buffer
+	for _, broker := range data {
+		if len(broker.Raw) == 0 {
+			continue
+		}
+		buf.Reset()
+		if err := json.Compact(&buf, broker.Raw); err != nil {
+			logErrorf("Error compacting raw JSON for CRD %s: %v", broker.CRD, err)
+			continue
+		}
+		buf.WriteByte('\n')
+		if _, err := out.Write(buf.Bytes()); err != nil {
+			logErrorf("Error writing raw NDJSON file: %v", err)
+			return written, err
+		}
+		written++
+	}
+	if err := out.Commit(); err != nil {
+		logErrorf("Error writing raw NDJSON file: %v", err)
+		return 0, err
+	}
+	log.Printf("Successfully saved %d raw records to %s", written, describeOutput(filename))
+	return written, nil
+}
+
+// saveCRDList writes the distinct CRDs, sorted, one per line. CRDs are
+// compared as numbers when both are numeric so 99 sorts before 100.
+func saveCRDList(data []BrokerSource, filename string) (int, error) {
+	seen := make(map[string]bool)
+	var crds []string
+	for _, broker := range data {
+		if broker.CRD == "" || seen[broker.CRD] {
+			continue
+		}
+		seen[broker.CRD] = true
+		crds = append(crds, broker.CRD)
+	}
+	sort.Slice(crds, func(i, j int) bool {
+		a, errA := strconv.Atoi(crds[i])
+		b, errB := strconv.Atoi(crds[j])
+		if errA == nil && errB == nil {
+			return a < b
+		}
+		return crds[i] < crds[j]
+	})
+
+	out, err := createOutput(filename)
+	if err != nil {
+		logErrorf("Error creating CRD list: %v", err)
+		return 0, err
+	}
+	defer out.Close()
+	w := bufio.NewWriter(out)
+	for _, crd := range crds {
+		w.WriteString(crd)
+		w.WriteByte('\n')
+	}
+	if err := w.Flush(); err != nil {
+		logErrorf("Error writing CRD list: %v", err)
+		return 0, err
+	}
+	if err := out.Commit(); err != nil {
+		logErrorf("Error writing CRD list: %v", err)
+		return 0, err
+	}
+	log.Printf("Successfully saved %d CRDs to %s", len(crds), describeOutput(filename))
+	return len(crds), nil
+}
+
+// csvOptions controls the layout of the CSV output
+type csvOptions struct {
+	Index           bool // Start with an Index column (the broker's position)
+	Flatten         bool // One row per (broker, employment) pair
+	Comma           rune // Field delimiter; zero means ','
+	FloatPrecision  int  // Decimal places for float columns
+	YearsExperience bool // Add a YearsExperience column
+	Source          bool // Add a Source column naming each broker's search
+	Watchlist       bool // Add WatchlistMatch and WatchlistScore columns
+	Exams           bool // Add an Exams column (semicolon-separated)
+	County          bool // Add FirmCounty and FirmFIPS columns
+	StateName       bool // Add a FirmStateName column
+	NoPlaceholder   bool // Skip the row of a broker with no employment to show
+	SortColumns     bool // Emit columns sorted by header instead of the curated order
+}
+
+// csvRow is what a CSV column is computed from: a broker and, if the row
+// has one, the employment it describes
+type csvRow struct {
+	Broker    *BrokerSource
+	Emp       *Employment // nil when the broker has no employment to show
+	IsCurrent bool
+}
+
+// csvColumn is one CSV column: its header and how to fill it in
+type csvColumn struct {
+	Header string
+	Value  func(r csvRow) string
+}
+
+// empColumn builds a column from an employment field, empty when the row
+// has no employment
+func empColumn(header string, field func(e *Employment) string) csvColumn {
+	return csvColumn{header, func(r csvRow) string {
+		if r.Emp == nil {
+			return ""
+		}
+		return field(r.Emp)
+	}}
+}
+
+// csvColumns returns the columns for the chosen layout, in order
+func csvColumns(opts csvOptions) []csvColumn {
+	cols := []csvColumn{
+		{"CRD", func(r csvRow) string { return r.Broker.CRD }},
+		{"FirstName", func(r csvRow) string { return r.Broker.FirstName }},
+		{"LastName", func(r csvRow) string { return r.Broker.LastName }},
+		empColumn("FirmName", func(e *Employment) string { return e.FirmName }),
+		empColumn("FirmCity", func(e *Employment) string { return e.City }),
+		empColumn("FirmState", func(e *Employment) string { return e.State }),
+	}
+	if opts.StateName {
+		cols = append(cols, empColumn("FirmStateName", func(e *Employment) string { return e.StateName }))
+	}
+	cols = append(cols, empColumn("FirmZip", func(e *Employment) string { return e.Zip }))
+	if opts.County {
+		cols = append(cols,
+			empColumn("FirmCounty", func(e *Employment) string { return e.County }),
+			empColumn("FirmFIPS", func(e *Employment) string { return e.FIPS }),
+		)
+	}
+	if opts.Flatten {
+		cols = append(cols, csvColumn{"IsCurrent", func(r csvRow) string {
+			if r.Emp == nil {
+				return ""
+			}
+			return strconv.FormatBool(r.IsCurrent)
+		}})
+	}
+	// FirmCRD comes after the original columns so their positions don't move
+	cols = append(cols, empColumn("FirmCRD", func(e *Employment) string { return e.FirmCRD }))
+	if opts.Flatten {
+		cols = append(cols,
+			empColumn("RegistrationBegin", func(e *Employment) string { return e.BeginDate }),
+			empColumn("RegistrationEnd", func(e *Employment) string { return e.EndDate }),
+		)
+	}
+	if opts.YearsExperience {
+		cols = append(cols, csvColumn{"YearsExperience", func(r csvRow) string {
+			return formatFloat(r.Broker.YearsExperience, opts.FloatPrecision)
+		}})
+	}
+	cols = append(cols,
+		csvColumn{"NumCurrentFirms", func(r csvRow) string { return strconv.Itoa(r.Broker.NumCurrentFirms) }},
+		csvColumn{"ProfileURL", func(r csvRow) string { return r.Broker.ProfileURL }},
+	)
+	if opts.Exams {
+		cols = append(cols, csvColumn{"Exams", func(r csvRow) string { return strings.Join(r.Broker.Exams, ";") }})
+	}
+	if opts.Source {
+		cols = append(cols, csvColumn{"Source", func(r csvRow) string { return r.Broker.Source }})
+	}
+	if opts.Watchlist {
+		cols = append(cols,
+			csvColumn{"WatchlistMatch", func(r csvRow) string { return r.Broker.WatchlistMatch }},
+			csvColumn{"WatchlistScore", func(r csvRow) string { return formatFloat(r.Broker.WatchlistScore, 3) }},
+		)
+	}
+	if opts.Index {
+		cols = append([]csvColumn{{"Index", func(r csvRow) string { return strconv.Itoa(r.Broker.Index) }}}, cols...)
+	}
+	if opts.SortColumns {
+		sort.Slice(cols, func(i, j int) bool { return cols[i].Header < cols[j].Header })
+	}
+	return cols
+}
+
+// formatFloat renders a float column rounded to precision decimals, and
+// leaves zero (unknown) values empty. JSON output keeps full precision.
+func formatFloat(v float64, precision int) string {
+	if v == 0 {
+		return ""
+	}
+	return strconv.FormatFloat(v, 'f', precision, 64)
+}
+
+// csvRows expands a broker into its CSV rows. By default that is a single
+// row holding the first current employment. With Flatten, every employment
+// (current and previous) gets its own row; a broker with no employments at
+// all still gets one row with the employment columns left empty.
+func csvRows(broker *BrokerSource, opts csvOptions) []csvRow {
+	if !opts.Flatten {
+		row := csvRow{Broker: broker, IsCurrent: true}
+		// Safely get the first employment record
+		if len(broker.CurrentEmployments) > 0 {
+			row.Emp = &broker.CurrentEmployments[0]
+		}
+		return []csvRow{row}
+	}
+
+	if len(broker.CurrentEmployments) == 0 && len(broker.PreviousEmployments) == 0 {
+		return []csvRow{{Broker: broker}}
+	}
+	var rows []csvRow
+	for i := range broker.CurrentEmployments {
+		rows = append(rows, csvRow{Broker: broker, Emp: &broker.CurrentEmployments[i], IsCurrent: true})
+	}
+	for i := range broker.PreviousEmployments {
+		rows = append(rows, csvRow{Broker: broker, Emp: &broker.PreviousEmployments[i], IsCurrent: false})
+	}
+	return rows
+}
+
+// saveToCSV writes the brokers as CSV. By default each broker is one row
+// holding its first current employment. With Flatten set, every employment
+// (current and previous) gets its own row with the broker fields repeated.
+func saveToCSV(data []BrokerSource, filename string, opts csvOptions) (int, error) {
+	file, err := createOutput(filename)
+	if err != nil {
+		logErrorf("Error creating CSV file: %v", err)
+		return 0, err
+	}
+	defer file.Close()
+
+	cw := newCSVRecordWriter(file, opts)
+	for i := range data {
+		cw.Write(&data[i])
+	}
+	rows, err := cw.Close()
+	if err != nil {
+		logErrorf("Error writing CSV file: %v", err)
+		return 0, err
+	}
+	if err := file.Commit(); err != nil {
+		logErrorf("Error writing CSV file: %v", err)
+		return 0, err
+	}
+	log.Printf("Successfully saved to %s", filename)
+	if opts.NoPlaceholder {
+		log.Printf("No placeholder rows: skipped %d brokers without an employment in %s", cw.skipped, filename)
+	}
+	return rows, nil
+}
+
+// parseDelimiter validates a -delimiter value. It must be a single rune;
+// "\t" and "tab" are accepted as spellings of the tab character.
+func parseDelimiter(s string) (rune, error) {
+	switch s {
+	case `\t`, "tab":
+		return '\t', nil
+	}
+	r := []rune(s)
+	if len(r) != 1 {
+		return 0, fmt.Errorf("delimiter must be a single character, got %q", s)
+	}
+	if r[0] == '"' || r[0] == '\r' || r[0] == '\n' || r[0] == utf8.RuneError {
+		return 0, fmt.Errorf("%q can't be used as a CSV delimiter", s)
+	}
+	return r[0], nil
+}
+
+// printHead writes a quick table of the first n brokers (CRD, name and
+// first current firm) to w
+func printHead(w io.Writer, data []BrokerSource, n int) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "CRD\tNAME\tFIRM")
+	for i, broker := range data {
+		if i >= n {
+			break
+		}
+		var firm string
+		if len(broker.CurrentEmployments) > 0 {
+			firm = broker.CurrentEmployments[0].FirmName
+		}
+		fmt.Fprintf(tw, "%s\t%s %s\t%s\n", broker.CRD, broker.FirstName, broker.LastName, firm)
+	}
+	tw.Flush()
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Per-page Output
+// -per-page-output writes every search page to its own numbered JSON file
+// as soon as it arrives, so downstream jobs can start on finished pages
+// while the scrape is still running.
+
+// pageWriter numbers and writes the pages of one search
+type pageWriter struct {
+	dir    string
+	prefix string // Region label for multi-region runs, "" otherwise
+	pages  int
+}
+
+func newPageWriter(dir, prefix string) (*pageWriter, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &pageWriter{dir: dir, prefix: prefix}, nil
+}
+
+// Write saves the next page as page-0001.json, page-0002.json, ... (or
+// nyc-page-0001.json with a prefix)
+func (w *pageWriter) Write(page []BrokerSource) error {
+	w.pages++
+	name := fmt.Sprintf("page-%04d.json", w.pages)
+	if w.prefix != "" {
+		name = w.prefix + "-" + name
+	}
+	_, err := saveToJSON(page, filepath.Join(w.dir, name), false)
+	return err
+}
+
+// collectPages runs s through Stream, gathering every broker and writing
+// each page to pageDir first if it is set
+func collectPages(ctx context.Context, s *Scraper, pageDir, prefix string) ([]BrokerSource, error) {
+	if pageDir == "" {
+		return s.Run(ctx)
+	}
+	w, err := newPageWriter(pageDir, prefix)
+	if err != nil {
+		return nil, err
+	}
+	var all []BrokerSource
+	err = s.Stream(ctx, func(page []BrokerSource) error {
+		if err := w.Write(page); err != nil {
+			return fmt.Errorf("writing page %d: %w", w.pages, err)
+		}
+		all = append(all, page...)
+		return nil
+	})
+	return all, err
+}
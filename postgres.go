@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// PostgreSQL Output
+// -format postgres upserts the brokers into a brokers table and their
+// employments into an employments table, in one transaction. Rows are
+// bulk-loaded with COPY into temporary tables and merged from there, so a
+// rerun updates brokers in place instead of duplicating them.
+
+// postgresSchema creates the tables on first use
+const postgresSchema = `
+CREATE TABLE IF NOT EXISTS brokers (
+	crd                 text PRIMARY KEY,
+	first_name          text NOT NULL,
+	last_name           text NOT NULL,
+	industry_start_date text NOT NULL,
+	years_experience    double precision NOT NULL,
+	num_current_firms   integer NOT NULL,
+	profile_url         text NOT NULL,
+	source              text NOT NULL,
+	exams               text[] NOT NULL,
+	updated_at          timestamptz NOT NULL
+);
+CREATE TABLE IF NOT EXISTS employments (
+	crd        text NOT NULL REFERENCES brokers (crd) ON DELETE CASCADE,
+	is_current boolean NOT NULL,
+	position   integer NOT NULL,
+	firm_crd   text NOT NULL,
+	firm_name  text NOT NULL,
+	city       text NOT NULL,
+	state      text NOT NULL,
+	zip        text NOT NULL,
+	PRIMARY KEY (crd, is_current, position)
+);`
+
+var (
+	brokerColumns     = []string{"crd", "first_name", "last_name", "industry_start_date", "years_experience", "num_current_firms", "profile_url", "source", "exams", "updated_at"}
+	employmentColumns = []string{"crd", "is_current", "position", "firm_crd", "firm_name", "city", "state", "zip"}
+)
+
+// saveToPostgres upserts the brokers by CRD into the database at dsn.
+// Brokers without a CRD can't be keyed and are skipped; of repeated CRDs
+// the first is kept.
+func saveToPostgres(data []BrokerSource, dsn string) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		logErrorf("Error opening Postgres connection: %v", err)
+		return 0, err
+	}
+	defer db.Close()
+	if err := db.PingContext(ctx); err != nil {
+		logErrorf("Error connecting to Postgres (check -dsn): %v", err)
+		return 0, err
+	}
+
+	n, err := upsertBrokers(ctx, db, data)
+	if err != nil {
+		logErrorf("Error writing to Postgres, nothing was saved: %v", err)
+		return 0, err
+	}
+	log.Printf("Successfully upserted %d brokers into Postgres", n)
+	return n, nil
+}
+
+// upsertBrokers does the work of saveToPostgres inside one transaction
+func upsertBrokers(ctx context.Context, db *sql.DB, data []BrokerSource) (int, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	for _, stmt := range []string{
+		postgresSchema,
+		`CREATE TEMP TABLE brokers_load (LIKE brokers) ON COMMIT DROP`,
+		`CREATE TEMP TABLE employments_load (LIKE employments) ON COMMIT DROP`,
+	} {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return 0, err
+		}
+	}
+
+	now := time.Now()
+	seen := make(map[string]bool)
+	var brokerRows, empRows [][]any
+	for _, b := range data {
+		if b.CRD == "" || seen[b.CRD] {
+			continue
+		}
+		seen[b.CRD] = true
+		exams := b.Exams
+		if exams == nil {
+			exams = []string{}
+		}
+		brokerRows = append(brokerRows, []any{b.CRD, b.FirstName, b.LastName, b.IndustryStartDate,
+			yearsExperience(&b, now), b.NumCurrentFirms, b.ProfileURL, b.Source, pq.Array(exams), now})
+		for i, e := range b.CurrentEmployments {
+			empRows = append(empRows, []any{b.CRD, true, i, e.FirmCRD, e.FirmName, e.City, e.State, e.Zip})
+		}
+		for i, e := range b.PreviousEmployments {
+			empRows = append(empRows, []any{b.CRD, false, i, e.FirmCRD, e.FirmName, e.City, e.State, e.Zip})
+		}
+	}
+	if skipped := len(data) - len(brokerRows); skipped > 0 {
+		log.Printf("Postgres: skipped %d brokers with an empty or repeated CRD", skipped)
+	}
+
+	if err := copyRows(ctx, tx, "brokers_load", brokerColumns, brokerRows); err != nil {
+		return 0, fmt.Errorf("loading brokers: %w", err)
+	}
+	if err := copyRows(ctx, tx, "employments_load", employmentColumns, empRows); err != nil {
+		return 0, fmt.Errorf("loading employments: %w", err)
+	}
+
+	// Employments are replaced wholesale for every broker in this batch
+	var updates []string
+	for _, col := range brokerColumns[1:] {
+		updates = append(updates, col+" = EXCLUDED."+col)
+	}
+	for _, stmt := range []string{
+		`INSERT INTO brokers SELECT * FROM brokers_load ON CONFLICT (crd) DO UPDATE SET ` + strings.Join(updates, ", "),
+		`DELETE FROM employments WHERE crd IN (SELECT crd FROM brokers_load)`,
+		`INSERT INTO employments SELECT * FROM employments_load`,
+	} {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return 0, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return len(brokerRows), nil
+}
+
+// copyRows bulk-loads rows into table with COPY
+func copyRows(ctx context.Context, tx *sql.Tx, table string, columns []string, rows [][]any) error {
+	stmt, err := tx.PrepareContext(ctx, pq.CopyIn(table, columns...))
+	if err != nil {
+		return err
+	}
+	for _, row := range rows {
+		if _, err := stmt.ExecContext(ctx, row...); err != nil {
+			stmt.Close()
+			return err
+		}
+	}
+	// The final empty Exec flushes the COPY
+	if _, err := stmt.ExecContext(ctx); err != nil {
+		stmt.Close()
+		return err
+	}
+	return stmt.Close()
+}
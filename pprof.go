@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	_ "net/http/pprof" // Registers /debug/pprof/ on http.DefaultServeMux
+)
+
+// Profiling
+// -pprof serves the net/http/pprof endpoints during the run, e.g.
+// `go tool pprof http://localhost:6060/debug/pprof/profile`. They expose
+// internals, so only loopback addresses are accepted.
+
+// resolvePprofAddr checks a -pprof address, defaulting an empty host to
+// 127.0.0.1, and rejects anything that isn't loopback
+func resolvePprofAddr(addr string) (string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", err
+	}
+	switch host {
+	case "":
+		host = "127.0.0.1"
+	case "localhost":
+	default:
+		ip := net.ParseIP(host)
+		if ip == nil || !ip.IsLoopback() {
+			return "", fmt.Errorf("%s is not a loopback address; use localhost, 127.0.0.1 or ::1", host)
+		}
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+// startPprof serves the profiling endpoints on addr in the background. It
+// returns once the listener is open, so a bad address fails the run early.
+func startPprof(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	log.Printf("Profiling endpoints at http://%s/debug/pprof/", ln.Addr())
+	go func() {
+		if err := http.Serve(ln, http.DefaultServeMux); err != nil {
+			logErrorf("pprof server stopped: %v", err)
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Progress Stream
+// -progress-json writes progress as JSON lines for a UI wrapping the
+// scraper, next to the human "Progress" log lines: a "page" event after
+// each search page, a "detail" event as -detail lookups finish, and a
+// final "done" event with the run's summary. The target is a file, or
+// fd:N for a descriptor the parent process opened (e.g. fd:3).
+
+// progressLine is one progress event
+type progressLine struct {
+	Event     string `json:"event"`
+	Timestamp string `json:"timestamp"`
+	Fetched   int    `json:"fetched"`
+	Total     int    `json:"total"`
+}
+
+// doneLine is the final event
+type doneLine struct {
+	Event          string  `json:"event"`
+	Timestamp      string  `json:"timestamp"`
+	Reported       int     `json:"reported"`
+	PagesAttempted int     `json:"pages_attempted"`
+	PagesFetched   int     `json:"pages_fetched"`
+	Requests       int     `json:"requests"`
+	Records        int     `json:"records"`
+	Saved          int     `json:"saved"`
+	Files          int     `json:"files"`
+	ElapsedSeconds float64 `json:"elapsed_seconds"`
+	Interrupted    bool    `json:"interrupted,omitempty"`
+	Error          string  `json:"error,omitempty"`
+}
+
+// progressStream writes progress events as JSON Lines. Its methods are
+// safe to call from several goroutines.
+type progressStream struct {
+	mu   sync.Mutex
+	file *os.File
+	enc  *json.Encoder
+}
+
+// openProgressStream opens target, a filename or fd:N
+func openProgressStream(target string) (*progressStream, error) {
+	var file *os.File
+	if fd, ok := strings.CutPrefix(target, "fd:"); ok {
+		n, err := strconv.Atoi(fd)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid file descriptor %q", fd)
+		}
+		file = os.NewFile(uintptr(n), target)
+		if _, err := file.Stat(); err != nil {
+			return nil, fmt.Errorf("file descriptor %d isn't open: %w", n, err)
+		}
+	} else {
+		var err error
+		file, err = os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &progressStream{file: file, enc: json.NewEncoder(file)}, nil
+}
+
+// Progress matches ProgressFunc, writing a page or detail event
+func (ps *progressStream) Progress(phase string, done, total int) {
+	event := "page"
+	if phase == PhaseDetail {
+		event = "detail"
+	}
+	ps.write(progressLine{Event: event, Timestamp: progressTime(), Fetched: done, Total: total})
+}
+
+// Done writes the final summary event
+func (ps *progressStream) Done(st RunStats, saved, files int, elapsed time.Duration, interrupted bool, scrapeErr error) {
+	line := doneLine{
+		Event:          "done",
+		Timestamp:      progressTime(),
+		Reported:       st.Reported,
+		PagesAttempted: st.PagesAttempted,
+		PagesFetched:   st.PagesFetched,
+		Requests:       st.Requests,
+		Records:        st.RecordsFetched,
+		Saved:          saved,
+		Files:          files,
+		ElapsedSeconds: elapsed.Round(time.Millisecond).Seconds(),
+		Interrupted:    interrupted,
+	}
+	if scrapeErr != nil {
+		line.Error = scrapeErr.Error()
+	}
+	ps.write(line)
+}
+
+func (ps *progressStream) write(v any) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	if err := ps.enc.Encode(v); err != nil {
+		logErrorf("Error writing to progress stream: %v", err)
+	}
+}
+
+func (ps *progressStream) Close() error {
+	return ps.file.Close()
+}
+
+func progressTime() string {
+	return time.Now().UTC().Format(time.RFC3339Nano)
+}
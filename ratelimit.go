@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Rate Limiting
+// One limiter is shared by every request of a scrape (search pages, detail
+// lookups and every region alike), so adding workers never raises the
+// request rate.
+
+// adaptiveLimiter is a token bucket (golang.org/x/time/rate) refilled one
+// token per interval, holding up to burst tokens. The interval doubles (up
+// to max) whenever the server pushes back, with the burst dropped to 1,
+// and eases back toward base after successful requests.
+type adaptiveLimiter struct {
+	mu       sync.Mutex
+	base     time.Duration
+	max      time.Duration
+	burst    int
+	interval time.Duration
+	bucket   *rate.Limiter
+}
+
+// newAdaptiveLimiter returns a limiter allowing one request per base,
+// with bursts of up to burst (at least 1). A zero base doesn't limit.
+func newAdaptiveLimiter(base time.Duration, burst int) *adaptiveLimiter {
+	burst = max(burst, 1)
+	return &adaptiveLimiter{
+		base:     base,
+		max:      max(base*16, 30*time.Second),
+		burst:    burst,
+		interval: base,
+		bucket:   rate.NewLimiter(every(base), burst),
+	}
+}
+
+// every is the refill rate for one token per d; zero means unlimited
+func every(d time.Duration) rate.Limit {
+	if d <= 0 {
+		return rate.Inf
+	}
+	return rate.Every(d)
+}
+
+// Wait blocks until the caller may start a request or ctx is done
+func (l *adaptiveLimiter) Wait(ctx context.Context) error {
+	return l.bucket.Wait(ctx)
+}
+
+// Backoff slows the limiter down after the server signals overload
+func (l *adaptiveLimiter) Backoff() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.interval = min(max(l.interval*2, time.Second), l.max)
+	l.apply()
+}
+
+// Success lets the limiter recover a little after a good response
+func (l *adaptiveLimiter) Success() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.interval > l.base {
+		l.interval = max(l.interval*3/4, l.base)
+		l.apply()
+	}
+}
+
+// apply pushes the current interval to the bucket; bursts are only
+// allowed at full speed. Called with mu held.
+func (l *adaptiveLimiter) apply() {
+	l.bucket.SetLimit(every(l.interval))
+	if l.interval > l.base {
+		l.bucket.SetBurst(1)
+	} else {
+		l.bucket.SetBurst(l.burst)
+	}
+}
+
+// Interval reports the current spacing between requests
+func (l *adaptiveLimiter) Interval() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.interval
+}
+
+// GlobalThrottle lets several Scrapers (e.g. one per region) react to the
+// server together: a 429/503 seen by any of them pauses them all, instead
+// of the others carrying on and tripping the same per-IP limit.
+type GlobalThrottle struct {
+	mu    sync.Mutex
+	until time.Time // No request starts before this
+}
+
+func NewGlobalThrottle() *GlobalThrottle {
+	return &GlobalThrottle{}
+}
+
+// Pause holds back every sharing Scraper for d from now. It reports
+// whether this extended the current pause.
+func (g *GlobalThrottle) Pause(d time.Duration) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	until := time.Now().Add(d)
+	if !until.After(g.until) {
+		return false
+	}
+	g.until = until
+	return true
+}
+
+// Wait blocks while a global pause is in effect. A nil throttle never
+// waits.
+func (g *GlobalThrottle) Wait(ctx context.Context) error {
+	if g == nil {
+		return nil
+	}
+	g.mu.Lock()
+	delay := time.Until(g.until)
+	g.mu.Unlock()
+	if delay <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// rampDelay is how long worker i of n waits before its first request: an
+// even share of ramp plus up to one share of random jitter, so concurrent
+// workers ramp up instead of all firing at t=0. Zero ramp means no wait.
+func rampDelay(i, n int, ramp time.Duration) time.Duration {
+	if ramp <= 0 || n <= 1 {
+		return 0
+	}
+	slot := ramp / time.Duration(n)
+	return time.Duration(i)*slot + rand.N(slot+1)
+}
+
+// sleepCtx sleeps for d, returning early with ctx's error if it is done
+func sleepCtx(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
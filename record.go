@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// Recording and Replay
+// -record-dir saves every API exchange to disk; -replay-dir serves requests
+// from such a directory instead of the network. Each exchange is one JSON
+// file named after a hash of the request URL.
+
+// recordedExchange is the on-disk form of one request/response
+type recordedExchange struct {
+	URL    string      `json:"url"`
+	Status int         `json:"status"`
+	Header http.Header `json:"header"`
+	Body   string      `json:"body"`
+}
+
+// recordingPath is where the exchange for url lives within dir
+func recordingPath(dir, url string) string {
+	sum := sha256.Sum256([]byte(url))
+	return filepath.Join(dir, hex.EncodeToString(sum[:8])+".json")
+}
+
+// recordingTransport passes requests through to next and saves each
+// response to dir
+type recordingTransport struct {
+	dir  string
+	next http.RoundTripper
+}
+
+func (t *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	rec, err := captureExchange(req, resp)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeExchange(recordingPath(t.dir, rec.URL), rec); err != nil {
+		logErrorf("Error recording response for %s: %v", rec.URL, err)
+	}
+	return resp, nil
+}
+
+// captureExchange reads resp's body into a recordedExchange, leaving resp
+// readable again for the caller
+func captureExchange(req *http.Request, resp *http.Response) (recordedExchange, error) {
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return recordedExchange{}, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	// Recordings hold plain text so they stay readable (and valid JSON
+	// strings); the sniffing in decodeBody makes that transparent.
+	plain, err := gunzip(body)
+	if err != nil {
+		return recordedExchange{}, err
+	}
+	header := resp.Header.Clone()
+	header.Del("Content-Encoding")
+	return recordedExchange{
+		URL:    req.URL.String(),
+		Status: resp.StatusCode,
+		Header: header,
+		Body:   string(plain),
+	}, nil
+}
+
+// writeExchange saves rec as indented JSON at path
+func writeExchange(path string, rec recordedExchange) error {
+	data, err := json.MarshalIndent(rec, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// readExchange loads the exchange saved at path and replays it as a
+// response to req
+func readExchange(path string, req *http.Request) (*http.Response, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var rec recordedExchange
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+	return &http.Response{
+		Status:     fmt.Sprintf("%d %s", rec.Status, http.StatusText(rec.Status)),
+		StatusCode: rec.Status,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     rec.Header,
+		Body:       io.NopCloser(bytes.NewReader([]byte(rec.Body))),
+		Request:    req,
+	}, nil
+}
+
+// replayTransport answers requests from recordings in dir and never
+// touches the network
+type replayTransport struct {
+	dir string
+}
+
+func (t *replayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	url := req.URL.String()
+	path := recordingPath(t.dir, url)
+	if _, err := os.Stat(path); err != nil {
+		return nil, fmt.Errorf("no recording for %s in %s", url, t.dir)
+	}
+	resp, err := readExchange(path, req)
+	if err != nil {
+		return nil, fmt.Errorf("reading recording for %s: %w", url, err)
+	}
+	return resp, nil
+}
+
+// setupRecording wraps the scraper's transport for -record-dir or replaces
+// it for -replay-dir
+func setupRecording(scraper *Scraper, recordDir, replayDir string) error {
+	switch {
+	case recordDir != "" && replayDir != "":
+		return fmt.Errorf("-record-dir and -replay-dir can't be used together")
+	case replayDir != "":
+		if _, err := os.Stat(replayDir); err != nil {
+			return err
+		}
+		scraper.Client.Transport = &replayTransport{dir: replayDir}
+	case recordDir != "":
+		if err := os.MkdirAll(recordDir, 0755); err != nil {
+			return err
+		}
+		next := scraper.Client.Transport
+		if next == nil {
+			next = http.DefaultTransport
+		}
+		scraper.Client.Transport = &recordingTransport{dir: recordDir, next: next}
+	}
+	return nil
+}
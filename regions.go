@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Region Presets
+// Named metro areas for -region, so common searches don't need
+// coordinates. Radii are in miles and roughly cover each metro's core.
+// Several regions can be given at once; each is scraped separately and the
+// results are merged before dedup.
+
+type regionPreset struct {
+	Lat    string
+	Lon    string
+	Radius string
+}
+
+var regionPresets = map[string]regionPreset{
+	"atlanta":      {"33.748997", "-84.387985", "25"},
+	"boston":       {"42.360081", "-71.058884", "15"},
+	"chicago":      {"41.878113", "-87.629799", "20"},
+	"dallas":       {"32.776665", "-96.796989", "25"},
+	"dc":           {latitude, longitude, radius},
+	"denver":       {"39.739235", "-104.990250", "25"},
+	"houston":      {"29.760427", "-95.369804", "25"},
+	"la":           {"34.052235", "-118.243683", "25"},
+	"miami":        {"25.761681", "-80.191788", "20"},
+	"nyc":          {"40.712776", "-74.005974", "15"},
+	"philadelphia": {"39.952583", "-75.165222", "20"},
+	"phoenix":      {"33.448376", "-112.074036", "25"},
+	"seattle":      {"47.606209", "-122.332069", "20"},
+	"sf":           {"37.774929", "-122.419418", "15"},
+}
+
+// regionNames returns the preset names in order
+func regionNames() []string {
+	names := make([]string, 0, len(regionPresets))
+	for name := range regionPresets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// printPresets writes one line per preset, sorted by name, for
+// -list-presets
+func printPresets(w io.Writer) {
+	for _, name := range regionNames() {
+		p := regionPresets[name]
+		fmt.Fprintf(w, "%-14s %s,%s radius %s mi\n", name, p.Lat, p.Lon, p.Radius)
+	}
+}
+
+// searchRegion is one area to scrape: a named preset, or the default
+// coordinates when Name is empty
+type searchRegion struct {
+	Name string
+	regionPreset
+}
+
+// label is how -append-source-column names the region
+func (r searchRegion) label() string {
+	if r.Name != "" {
+		return r.Name
+	}
+	if r.Lat == "" {
+		return "query" // -query with no search area
+	}
+	return r.Lat + "," + r.Lon
+}
+
+// parseRegionList resolves a comma-separated -region value. An empty list
+// means the single default search.
+func parseRegionList(list string) ([]searchRegion, error) {
+	if strings.TrimSpace(list) == "" {
+		return []searchRegion{{regionPreset: regionPreset{latitude, longitude, radius}}}, nil
+	}
+	var regions []searchRegion
+	seen := make(map[string]bool)
+	for _, name := range strings.Split(list, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		preset, err := lookupRegion(name)
+		if err != nil {
+			return nil, err
+		}
+		regions = append(regions, searchRegion{Name: name, regionPreset: preset})
+	}
+	return regions, nil
+}
+
+// runRegions scrapes the regions, up to -max-concurrent-regions at a time,
+// each with its own Scraper built from base's config, client and hooks
+// (so each keeps its own -max-concurrent page limit), and returns the
+// brokers in region order. The regions share base's -rps limiter and
+// bandwidth cap. With -throttle-on-429-global, a 429/503 in any region
+// pauses all of them, since the API's limit is per client IP rather than
+// per search.
+func runRegions(ctx context.Context, base *Scraper, opts *options) ([]BrokerSource, error) {
+	regions := opts.regions
+	var throttle *GlobalThrottle
+	if opts.GlobalThrottle {
+		throttle = NewGlobalThrottle()
+	}
+
+	concurrent := min(max(opts.MaxConcurrentRegions, 1), len(regions))
+	slots := make(chan struct{}, concurrent)
+	var launched atomic.Int32
+	results := make([][]BrokerSource, len(regions))
+	errs := make([]error, len(regions))
+	stats := make([]RunStats, len(regions))
+	var wg sync.WaitGroup
+	for i, r := range regions {
+		cfg := base.Config
+		cfg.Latitude, cfg.Longitude, cfg.Radius = r.Lat, r.Lon, r.Radius
+		if opts.AppendSource {
+			cfg.SourceLabel = r.label()
+		}
+		sub := NewScraper(cfg)
+		sub.Client = base.Client
+		sub.ProgressFunc = base.ProgressFunc
+		sub.BetweenPages = base.BetweenPages
+		sub.ErrorFunc = base.ErrorFunc
+		sub.Throttle = throttle
+		// One bandwidth cap and one -rps limiter across all regions; the
+		// -max-concurrent slots are the sub-Scraper's own
+		sub.bandwidth = base.bandwidth
+		sub.limiter = base.limiter
+
+		wg.Go(func() {
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+				errs[i] = fmt.Errorf("region %s: %w", r.label(), ctx.Err())
+				return
+			}
+			defer func() { <-slots }()
+
+			// Only the first wave is staggered; later regions start as
+			// slots free up
+			if n := int(launched.Add(1)) - 1; n < concurrent {
+				if err := sleepCtx(ctx, rampDelay(n, concurrent, base.Config.Ramp)); err != nil {
+					errs[i] = fmt.Errorf("region %s: %w", r.label(), err)
+					return
+				}
+			}
+
+			log.Printf("Region %s: searching %s, %s within %s miles", r.label(), r.Lat, r.Lon, r.Radius)
+			results[i], errs[i] = scrapeSearch(ctx, sub, opts, r.label())
+			if errs[i] != nil {
+				errs[i] = fmt.Errorf("region %s: %w", r.label(), errs[i])
+			}
+			stats[i] = sub.Stats
+		})
+	}
+	wg.Wait()
+
+	// Overlap: how many regions found each CRD
+	found := make(map[string]int)
+	for _, brokers := range results {
+		for _, crd := range distinctCRDs(brokers) {
+			found[crd]++
+		}
+	}
+
+	var all []BrokerSource
+	base.Stats = RunStats{}
+	for i, r := range regions {
+		shared := 0
+		for _, crd := range distinctCRDs(results[i]) {
+			if found[crd] > 1 {
+				shared++
+			}
+		}
+		log.Printf("Region %s: collected %d brokers (%d also found by other regions)", r.label(), len(results[i]), shared)
+		all = append(all, results[i]...)
+		base.Stats.Reported += stats[i].Reported
+		base.Stats.add(stats[i])
+	}
+	multi := 0
+	for _, n := range found {
+		if n > 1 {
+			multi++
+		}
+	}
+	log.Printf("Region overlap: %d distinct CRDs across %d regions, %d of them found by more than one", len(found), len(regions), multi)
+	return all, errors.Join(errs...)
+}
+
+// distinctCRDs lists the non-empty CRDs in brokers, once each
+func distinctCRDs(brokers []BrokerSource) []string {
+	seen := make(map[string]bool, len(brokers))
+	var crds []string
+	for _, b := range brokers {
+		if b.CRD != "" && !seen[b.CRD] {
+			seen[b.CRD] = true
+			crds = append(crds, b.CRD)
+		}
+	}
+	return crds
+}
+
+// lookupRegion finds a preset by name, listing the options if it's unknown
+func lookupRegion(name string) (regionPreset, error) {
+	preset, ok := regionPresets[strings.ToLower(name)]
+	if !ok {
+		return regionPreset{}, fmt.Errorf("unknown region %q (available: %s)", name, strings.Join(regionNames(), ", "))
+	}
+	return preset, nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/csv"
+	"io"
+	"strings"
+)
+
+// Results
+// Results wraps a scrape's brokers with the common post-processing and
+// serialization steps, so Go callers don't have to rebuild them. The CLI
+// goes through the same methods.
+
+// Results is a list of brokers, e.g. from Scraper.Run. Filtering methods
+// reuse the receiver's backing array, like the filters in transform.go.
+type Results []BrokerSource
+
+// FilterByState keeps brokers with a current employment in any of the
+// given state codes (case-insensitive)
+func (r Results) FilterByState(states ...string) Results {
+	wanted := make(map[string]bool)
+	for _, s := range states {
+		wanted[strings.ToUpper(strings.TrimSpace(s))] = true
+	}
+	return filterStates(r, wanted)
+}
+
+// DedupeByCRD drops repeated CRDs, keeping the first, and reports how many
+// were removed
+func (r Results) DedupeByCRD() (Results, int) {
+	return dedupe(r, dedupeByCRD)
+}
+
+// ToJSON writes the brokers as an indented JSON array, streamed one
+// broker at a time (see jsonstream.go), as saveToJSON does with the
+// default string CRDs
+func (r Results) ToJSON(w io.Writer) error {
+	arr := newJSONArrayWriter(w)
+	for i := range r {
+		if err := arr.Write(jsonRecord(&r[i], false)); err != nil {
+			return err
+		}
+	}
+	return arr.Close()
+}
+
+// ToCSV writes the brokers as CSV in the given layout and returns the number
+// of data rows
+func (r Results) ToCSV(w io.Writer, opts csvOptions) (int, error) {
+	cw := newCSVRecordWriter(w, opts)
+	for i := range r {
+		cw.Write(&r[i])
+	}
+	return cw.Close()
+}
+
+// csvRecordWriter writes brokers as CSV rows one at a time, after the
+// header row
+type csvRecordWriter struct {
+	w       *csv.Writer
+	opts    csvOptions
+	cols    []csvColumn
+	rows    int
+	skipped int // Placeholder rows left out with NoPlaceholder
+}
+
+// newCSVRecordWriter writes the header row for the layout in opts
+func newCSVRecordWriter(w io.Writer, opts csvOptions) *csvRecordWriter {
+	writer := csv.NewWriter(w)
+	if opts.Comma != 0 {
+		writer.Comma = opts.Comma
+	}
+
+	// Write Header
+	cols := csvColumns(opts)
+	header := make([]string, len(cols))
+	for i, col := range cols {
+		header[i] = col.Header
+	}
+	writer.Write(header)
+	return &csvRecordWriter{w: writer, opts: opts, cols: cols}
+}
+
+// Write writes the rows of one broker. Errors are reported by Close.
+func (cw *csvRecordWriter) Write(b *BrokerSource) {
+	for _, cr := range csvRows(b, cw.opts) {
+		if cr.Emp == nil && cw.opts.NoPlaceholder {
+			cw.skipped++
+			continue
+		}
+		row := make([]string, len(cw.cols))
+		for j, col := range cw.cols {
+			row[j] = col.Value(cr)
+		}
+		cw.w.Write(row)
+		cw.rows++
+	}
+}
+
+// Close flushes the rows and returns how many data rows were written
+func (cw *csvRecordWriter) Close() (int, error) {
+	cw.w.Flush()
+	return cw.rows, cw.w.Error()
+}
+
+// CountByFirm counts distinct brokers per current firm, keyed like
+// -group-by-firm: the firm CRD, or "name:" plus the firm name without one
+func (r Results) CountByFirm() map[string]int {
+	counts := make(map[string]int)
+	for _, f := range groupByFirm(r) {
+		counts[firmKey(Employment{FirmCRD: f.FirmCRD, FirmName: f.FirmName})] = len(f.Brokers)
+	}
+	return counts
+}
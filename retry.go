@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Retries
+// A request that fails in a way that may clear up by itself (a 429/5xx, a
+// dropped connection, a timeout) is tried again after an exponentially
+// growing, jittered delay, instead of ending the scrape on the first blip.
+
+// RetryPolicy says how often, and how patiently, failed requests are
+// retried. The zero value never retries.
+type RetryPolicy struct {
+	Attempts int           // Retries after the first try
+	Base     time.Duration // Delay before the first retry
+	Max      time.Duration // Cap on the doubling delay
+	Jitter   time.Duration // Up to this much random time added to each delay
+	Statuses []int         // HTTP statuses worth retrying; nil means defaultRetryStatuses
+}
+
+// defaultRetryStatuses are the statuses an overloaded or failing server
+// answers with: 429, 500, 502, 503 and 504
+var defaultRetryStatuses = []int{
+	http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+	http.StatusServiceUnavailable, http.StatusGatewayTimeout,
+}
+
+// formatStatusList writes statuses the way -retry-status takes them
+func formatStatusList(statuses []int) string {
+	parts := make([]string, len(statuses))
+	for i, code := range statuses {
+		parts[i] = strconv.Itoa(code)
+	}
+	return strings.Join(parts, ",")
+}
+
+// parseStatusList parses a comma-separated list of HTTP status codes. An
+// empty list retries no status at all.
+func parseStatusList(list string) ([]int, error) {
+	statuses := []int{}
+	for _, part := range strings.Split(list, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		code, err := strconv.Atoi(part)
+		if err != nil || code < 100 || code > 599 {
+			return nil, fmt.Errorf("%q isn't an HTTP status code", part)
+		}
+		if code == http.StatusOK {
+			return nil, fmt.Errorf("200 is a success, not a retryable status")
+		}
+		statuses = append(statuses, code)
+	}
+	return statuses, nil
+}
+
+// Delay is how long to wait before retry number attempt (1-based): Base
+// doubled per earlier retry, capped at Max, plus up to Jitter. The jitter
+// only affects timing, so it ignores -seed.
+func (p RetryPolicy) Delay(attempt int) time.Duration {
+	d := p.Base
+	for i := 1; i < attempt && d < p.Max; i++ {
+		d *= 2
+	}
+	d = min(d, p.Max)
+	if p.Jitter > 0 {
+		d += rand.N(p.Jitter + 1)
+	}
+	return d
+}
+
+// maxRetryAfter caps how long a Retry-After header can make a retry wait,
+// so a misconfigured server can't stall the scrape for hours
+const maxRetryAfter = 5 * time.Minute
+
+// parseRetryAfter reads a Retry-After header, given as seconds or as an
+// HTTP date, and returns 0 when it is missing or unreadable
+func parseRetryAfter(header string, now time.Time) time.Duration {
+	header = strings.TrimSpace(header)
+	if header == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(header); err == nil {
+		return min(max(time.Duration(secs)*time.Second, 0), maxRetryAfter)
+	}
+	if t, err := http.ParseTime(header); err == nil {
+		return min(max(t.Sub(now), 0), maxRetryAfter)
+	}
+	return 0
+}
+
+// isTransient reports whether err is worth retrying: a dropped or timed
+// out connection other than our own cancellation, or one of statuses (nil
+// means defaultRetryStatuses). Parse failures would only fail the same way
+// again.
+func isTransient(err error, statuses []int) bool {
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	if isConnDrop(err) {
+		return true
+	}
+	var fe *FetchError
+	if !errors.As(err, &fe) {
+		return errors.Is(err, context.DeadlineExceeded)
+	}
+	switch fe.Category {
+	case CategoryNetwork:
+		return true
+	case CategoryStatus:
+		if statuses == nil {
+			statuses = defaultRetryStatuses
+		}
+		return slices.Contains(statuses, fe.StatusCode)
+	}
+	return false
+}
+
+// isConnDrop reports whether err is the server or network cutting a
+// request short: an EOF mid-response ("unexpected EOF"), a connection
+// reset or abort, or a net.Error that times out or says it is temporary.
+// These are checked by value, whatever wraps them.
+func isConnDrop(err error) bool {
+	if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNABORTED) || errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+	var ne net.Error
+	if errors.As(err, &ne) {
+		if ne.Timeout() {
+			return true
+		}
+		// Temporary is deprecated but still the only signal some errors give
+		if t, ok := ne.(interface{ Temporary() bool }); ok && t.Temporary() {
+			return true
+		}
+	}
+	return false
+}
+
+// retryWait sleeps before retry number attempt of a request that failed
+// with err, and reports whether the retry should go ahead. A Retry-After
+// from the server is waited out in full when it is longer than the
+// backoff delay.
+func (s *Scraper) retryWait(ctx context.Context, attempt int, err error) bool {
+	p := s.Config.Retry
+	if attempt > p.Attempts || !isTransient(err, p.Statuses) || ctx.Err() != nil {
+		return false
+	}
+	d := p.Delay(attempt)
+	var fe *FetchError
+	if errors.As(err, &fe) && fe.RetryAfter > d {
+		d = fe.RetryAfter
+		log.Printf("Request failed (%v); retry %d/%d in %s, as the server's Retry-After asks", err, attempt, p.Attempts, d.Round(time.Millisecond))
+	} else {
+		log.Printf("Request failed (%v); retry %d/%d in %s", err, attempt, p.Attempts, d.Round(time.Millisecond))
+	}
+	return sleepCtx(ctx, d) == nil
+}
+
+// retryFailure is the error for a request that retryWait stopped retrying
+// at attempt: err marked with how many retries were spent when it kept
+// failing transiently, or err unchanged when it wasn't worth retrying (or
+// the scrape is stopping)
+func (s *Scraper) retryFailure(ctx context.Context, attempt int, err error) error {
+	p := s.Config.Retry
+	if p.Attempts > 0 && attempt > p.Attempts && ctx.Err() == nil && isTransient(err, p.Statuses) {
+		return fmt.Errorf("gave up after %d retries: %w", p.Attempts, err)
+	}
+	return err
+}
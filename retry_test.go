@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// TestRetryAfterConnReset has the server reset the first connection before
+// answering and checks the drop is retried and the page still arrives
+func TestRetryAfterConnReset(t *testing.T) {
+	body, err := os.ReadFile("testdata/search_page.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) == 1 {
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if err != nil {
+				t.Errorf("hijack: %v", err)
+				return
+			}
+			conn.(*net.TCPConn).SetLinger(0) // Close with a RST, not a FIN
+			conn.Close()
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(body)
+	}))
+	defer srv.Close()
+
+	s := NewScraper(Config{
+		APIURL:   srv.URL,
+		PageSize: minPageSize,
+		Delay:    -1,
+		Retry:    RetryPolicy{Attempts: 2, Base: time.Millisecond, Max: time.Millisecond},
+	})
+	var dropped []error
+	s.ErrorFunc = func(ev ErrorEvent) { dropped = append(dropped, ev.Err) }
+
+	resp, _, err := s.fetchAdaptive(context.Background(), 1, 0, minPageSize)
+	if err != nil {
+		t.Fatalf("fetch failed: %v", err)
+	}
+	if len(dropped) != 1 || !isConnDrop(dropped[0]) {
+		t.Fatalf("first attempt errors = %v, want one connection drop", dropped)
+	}
+	if got := requests.Load(); got != 2 {
+		t.Errorf("server saw %d requests, want 2", got)
+	}
+	if len(resp.Hits.Hits) != 100 {
+		t.Errorf("got %d hits, want 100", len(resp.Hits.Hits))
+	}
+}
+
+// TestIsConnDrop checks that drops are retried even without a FetchError
+// around them to mark them as network errors
+func TestIsConnDrop(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"unexpected EOF", fmt.Errorf("reading body: %w", io.ErrUnexpectedEOF), true},
+		{"connection reset", &net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}, true},
+		{"connection aborted", fmt.Errorf("page 3: %w", syscall.ECONNABORTED), true},
+		{"broken pipe", &net.OpError{Op: "write", Net: "tcp", Err: syscall.EPIPE}, true},
+		{"refused", &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}, false},
+		{"other", errors.New("bad request"), false},
+	} {
+		if got := isConnDrop(tc.err); got != tc.want {
+			t.Errorf("%s: isConnDrop = %v, want %v", tc.name, got, tc.want)
+		}
+		if got := isTransient(tc.err, nil); got != tc.want {
+			t.Errorf("%s: isTransient = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
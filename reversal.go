@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+)
+
+// Sort Reversal
+// A search with more results than paginationCap but no more than twice
+// that can still be listed in full without splitting the area: page
+// through it sorted one way up to the cap, then sorted the other way for
+// the rest. The two halves meet in the middle and the overlap is removed by
+// CRD. This only works if the sort field is stable and unique; with ties or
+// records changing between the two passes, a few brokers can be missed.
+
+// reversalSortField is what -sort-reversal sorts on. CRDs are unique and
+// don't change, so both passes agree on the order.
+const reversalSortField = "ind_source_id"
+
+// reversalOverlap is how many extra records the descending pass fetches
+// past the point where the halves should meet, to absorb small shifts
+// between the passes
+const reversalOverlap = 100
+
+// RunReversed scrapes the configured search, splitting it into an
+// ascending and a descending pass (see above) when its total is between
+// paginationCap and twice that. Each pass, or the whole search when no
+// split is needed, is handed to leaf as a copy of s, named "asc", "desc"
+// or "" in dir. s.Stats sums every pass.
+func (s *Scraper) RunReversed(ctx context.Context, leaf func(sub *Scraper, dir string) ([]BrokerSource, error)) ([]BrokerSource, error) {
+	total, err := s.Total(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("counting results: %w", err)
+	}
+	if total <= paginationCap {
+		return leaf(s, "")
+	}
+	if total > 2*paginationCap {
+		log.Printf("Warning: sort reversal: %d results is more than twice the %d cap; only %d are reachable this way (try -auto-subdivide)",
+			total, paginationCap, 2*paginationCap)
+	}
+
+	asc := *s
+	asc.Config.Sort = reversalSortField + "+asc"
+	asc.Config.MaxResults = paginationCap
+	desc := *s
+	desc.Config.Sort = reversalSortField + "+desc"
+	desc.Config.MaxResults = min(total-paginationCap+reversalOverlap, paginationCap)
+	log.Printf("Sort reversal: %d results; fetching %d ascending and %d descending by %s",
+		total, asc.Config.MaxResults, desc.Config.MaxResults, reversalSortField)
+
+	var all []BrokerSource
+	var errs []error
+	stats := RunStats{Reported: total}
+	for _, pass := range []struct {
+		dir string
+		sub *Scraper
+	}{{"asc", &asc}, {"desc", &desc}} {
+		brokers, err := leaf(pass.sub, pass.dir)
+		all = append(all, brokers...)
+		stats.add(pass.sub.Stats)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s pass: %w", pass.dir, err))
+			if ctx.Err() != nil {
+				break
+			}
+		}
+	}
+	s.Stats = stats
+
+	fetched := len(all)
+	all, dups := dedupe(all, dedupeByCRD)
+	log.Printf("Sort reversal: %d brokers from both passes, %d unique (%d overlap)", fetched, len(all), dups)
+	if len(all) < min(total, 2*paginationCap) {
+		log.Printf("Warning: sort reversal: got %d of %d results; the API may not sort by %s, or results changed between passes",
+			len(all), total, reversalSortField)
+	}
+	return all, errors.Join(errs...)
+}
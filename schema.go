@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"reflect"
+	"strings"
+)
+
+// Data Dictionary
+// -schema writes schema.json describing every output field. It is built
+// by reflection from the BrokerSource and Employment struct tags, so it
+// always matches the fields this build actually produces.
+
+// schemaField describes one output field
+type schemaField struct {
+	Name        string        `json:"name"`
+	Type        string        `json:"type"`
+	SourceKey   string        `json:"source_key,omitempty"` // Key in the API's _source, "" for derived fields
+	Description string        `json:"description"`
+	Optional    bool          `json:"optional,omitempty"` // Left out of the JSON when empty
+	Fields      []schemaField `json:"fields,omitempty"`   // Element fields for arrays of objects
+}
+
+// schemaDoc is the layout of schema.json
+type schemaDoc struct {
+	Record     []schemaField `json:"record"`      // One broker in brokers.json / brokers.ndjson
+	CSVColumns []string      `json:"csv_columns"` // brokers.csv header for this run's options
+}
+
+// schemaFields lists the JSON fields of a struct type from its tags
+func schemaFields(t reflect.Type) []schemaField {
+	var fields []schemaField
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+		sf := schemaField{
+			Name:        name,
+			Type:        jsonType(f.Type),
+			SourceKey:   name,
+			Description: f.Tag.Get("desc"),
+			Optional:    strings.Contains(opts, "omitempty"),
+		}
+		if f.Tag.Get("derived") == "true" {
+			sf.SourceKey = ""
+		}
+		if f.Type.Kind() == reflect.Slice && f.Type.Elem().Kind() == reflect.Struct {
+			sf.Fields = schemaFields(f.Type.Elem())
+		}
+		fields = append(fields, sf)
+	}
+	return fields
+}
+
+// jsonType names the JSON type a Go type encodes as
+func jsonType(t reflect.Type) string {
+	if t == reflect.TypeOf(json.RawMessage{}) {
+		return "object"
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int64, reflect.Float64:
+		return "number"
+	case reflect.Slice:
+		return "array"
+	case reflect.Struct, reflect.Map:
+		return "object"
+	}
+	return t.Kind().String()
+}
+
+// saveSchema writes the data dictionary for the current CSV options
+func saveSchema(filename string, csvOpts csvOptions) (int, error) {
+	doc := schemaDoc{Record: schemaFields(reflect.TypeOf(BrokerSource{}))}
+	for _, col := range csvColumns(csvOpts) {
+		doc.CSVColumns = append(doc.CSVColumns, col.Header)
+	}
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		logErrorf("Error marshaling schema: %v", err)
+		return 0, err
+	}
+	if err := writeFileAtomic(filename, append(data, '\n')); err != nil {
+		logErrorf("Error writing schema: %v", err)
+		return 0, err
+	}
+	log.Printf("Successfully saved to %s", filename)
+	return len(doc.Record), nil
+}
@@ -0,0 +1,1039 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Structs to Match the JSON Response
+// These are built to match the JSON output observed from Broker Check search output.
+
+type BrokerResponse struct {
+	Hits HitData `json:"hits"`
+}
+
+type HitData struct {
+	Total int         `json:"total"`
+	Hits  []BrokerHit `json:"hits"`
+}
+
+type BrokerHit struct {
+	Source BrokerSource `json:"_source"`
+}
+
+// BrokerSource contains the actual broker data. The desc tags (and
+// derived, for fields we compute ourselves) feed the -schema data dictionary.
+type BrokerSource struct {
+	// Index is the record's 1-based position in the final output order,
+	// set with -index
+	Index int `json:"index,omitempty" desc:"1-based position in the output order (-index only)" derived:"true"`
+
+	CRD                 string       `json:"ind_source_id" desc:"Broker's FINRA Central Registration Depository number"`
+	FirstName           string       `json:"ind_firstname" desc:"First name"`
+	LastName            string       `json:"ind_lastname" desc:"Last name"`
+	IndustryStartDate   string       `json:"ind_industry_cal_date" desc:"Date the broker first registered in the industry"`
+	CurrentEmployments  []Employment `json:"ind_current_employments" desc:"Current registrations, one per firm branch"`
+	PreviousEmployments []Employment `json:"ind_previous_employments" desc:"Previous registrations (empty with -include-previous=false)"`
+
+	// Detail is the full detail document, only filled in with -detail
+	Detail json.RawMessage `json:"detail,omitempty" desc:"Full detail document from the per-CRD endpoint (-detail only)" derived:"true"`
+
+	// Exams are the qualification exams passed (e.g. "Series 7"), read
+	// from Detail
+	Exams []string `json:"exams,omitempty" desc:"Qualification exams passed, e.g. Series 7 (-detail only)" derived:"true"`
+
+	// Raw is the untouched _source object from the API, kept with -raw so
+	// fields we don't model aren't lost. It is never part of our own output.
+	Raw json.RawMessage `json:"-"`
+
+	// Source names the search that produced this record, set with
+	// Config.SourceLabel (-append-source-column)
+	Source string `json:"source,omitempty" desc:"Search (region or lat,lon) that produced the record (-append-source-column only)" derived:"true"`
+
+	// Derived fields, computed by us after the scrape
+	YearsExperience float64 `json:"years_experience,omitempty" desc:"Years since ind_industry_cal_date, as of the run (-years-experience only)" derived:"true"`
+	NumCurrentFirms int     `json:"num_current_firms" desc:"Number of current employments" derived:"true"`
+	ProfileURL      string  `json:"profile_url,omitempty" desc:"BrokerCheck profile page for the CRD" derived:"true"`
+
+	// Watchlist match, set with -match-names-file
+	WatchlistMatch string  `json:"watchlist_match,omitempty" desc:"Watchlist name this broker matched (-match-names-file only)" derived:"true"`
+	WatchlistScore float64 `json:"watchlist_score,omitempty" desc:"Jaro-Winkler similarity to watchlist_match, 0 to 1 (-match-names-file only)" derived:"true"`
+}
+
+// Employment contains the firm's details.
+// The API gives no branch phone number, only the location fields below.
+type Employment struct {
+	FirmCRD  string `json:"firm_id" desc:"Firm's CRD number"`
+	FirmName string `json:"firm_name" desc:"Firm name"`
+	City     string `json:"branch_city" desc:"Branch office city"`
+	State    string `json:"branch_state" desc:"Branch office state code"`
+	Zip      string `json:"branch_zip" desc:"Branch office ZIP code"`
+
+	// BeginDate and EndDate bound the registration with the firm, as the
+	// API writes them (MM/DD/YYYY); a current employment has no EndDate
+	BeginDate string `json:"registration_begin_date,omitempty" desc:"Date the registration with this firm began"`
+	EndDate   string `json:"registration_end_date,omitempty" desc:"Date the registration with this firm ended (previous employments)"`
+
+	// County and FIPS locate the branch ZIP, set with -zip-county
+	County string `json:"branch_county,omitempty" desc:"County of the branch ZIP (-zip-county only)" derived:"true"`
+	FIPS   string `json:"branch_fips,omitempty" desc:"5-digit county FIPS code of the branch ZIP (-zip-county only)" derived:"true"`
+
+	// StateName spells out State, set with -state-names
+	StateName string `json:"branch_state_name,omitempty" desc:"Full name of the branch state (-state-names only)" derived:"true"`
+
+	// IsCurrent tells current employments from previous ones once they
+	// leave their slices (e.g. in flattened rows); set by deriveFields
+	IsCurrent bool `json:"is_current" desc:"True in ind_current_employments, false in ind_previous_employments" derived:"true"`
+}
+
+// API Search Parameters
+// These are from the URL found when inspecting Fetch/XHR of API from Broker Check website
+const (
+	apiURL    = "https://api.brokercheck.finra.org/search/individual"
+	siteURL   = "https://brokercheck.finra.org"
+	latitude  = "38.895568"  // For Washington D.C. area (example)
+	longitude = "-77.026278" // For Washington D.C. area (example)
+	radius    = "25"         // 25-mile radius
+	pageSize  = 100          // Get 100 results per page (max allowed is often 100 or 50)
+)
+
+// Config holds the search parameters for one scrape
+type Config struct {
+	APIURL    string
+	Latitude  string
+	Longitude string
+	Radius    string
+	Query     string // Free-text name search (the query parameter); with no Latitude, the search has no area
+	PageSize  int
+	Delay     time.Duration // Minimum spacing between requests (see NewScraper)
+
+	// Burst is how many requests may start back to back before Delay
+	// spacing applies, as a token bucket refilled one per Delay. Zero
+	// means 1.
+	Burst int
+
+	// MaxConcurrent caps how many requests are in flight at once across
+	// search and detail fetches. Zero means 1.
+	MaxConcurrent int
+
+	// Workers, if more than 1, fetches the pages after the first that many
+	// at a time (see fetchConcurrent). They still share the rate limiter
+	// and MaxConcurrent.
+	Workers int
+
+	// States, if set, is sent as a server-side state filter. The API
+	// doesn't document one, so callers should check it took effect (see
+	// checkStateFilter) and filter client-side as well.
+	States []string
+
+	// KeepRaw stores each hit's raw _source JSON in BrokerSource.Raw,
+	// exactly as received (before any FieldMap remapping)
+	KeepRaw bool
+
+	// Verbose logs extra diagnostics such as rate-limit response headers
+	Verbose bool
+
+	// Retry says how failed requests that may clear up by themselves are
+	// retried (see retry.go). The zero value fails on the first error.
+	Retry RetryPolicy
+
+	// Highlight sends hl=true, asking the API to wrap matched terms in
+	// <em> markup inside the returned fields. Off gives clean data.
+	Highlight bool
+
+	// OmitPrevious sends includePrevious=false so the API leaves out
+	// previous employments, shrinking every response.
+	OmitPrevious bool
+
+	// TLS, if set, is used for every connection (e.g. a client certificate
+	// for a mutual-TLS proxy, a custom CA, or a minimum TLS version).
+	TLS *tls.Config
+
+	// MinRadius is the smallest circle (miles) RunSubdivided splits down
+	// to. Zero means minSubdivideRadius.
+	MinRadius float64
+
+	// SourceIPs, if set, are local addresses to send requests from, taken
+	// round-robin per request (see sourceip.go)
+	SourceIPs []net.IP
+
+	// MaxBandwidth caps response bytes read per second across the
+	// Scraper's requests. Zero means no cap.
+	MaxBandwidth int64
+
+	// Ramp staggers the start of concurrent workers (detail fetches,
+	// regions) across this long, with jitter. Zero starts them together.
+	Ramp time.Duration
+
+	// StrictSchema fails a page whose _source objects have keys
+	// BrokerSource doesn't model, instead of ignoring them, as a canary
+	// for API changes
+	StrictSchema bool
+
+	// FieldMap, if set, supplies alternate JSON key names for fields the
+	// API has renamed (see fieldmap.go)
+	FieldMap FieldMap
+
+	// SourceLabel, if set, is stored in every collected broker's Source
+	// field so merged results can be traced back to their search
+	SourceLabel string
+
+	// Referer and Origin are sent as request headers when set. The
+	// website's own requests carry Origin https://brokercheck.finra.org
+	// and that origin plus "/" as Referer (the default
+	// strict-origin-when-cross-origin policy trims the page path).
+	Referer string
+	Origin  string
+
+	// Headers are extra request headers (-header-file), set after all of
+	// the above and replacing any of the same name
+	Headers http.Header
+
+	// Sort is the search's sort parameter, "field+asc" or "field+desc".
+	// Empty means "score+desc", the website's own order.
+	Sort string
+
+	// MaxIdle, if set, aborts the search when this long passes without a
+	// page that has results, e.g. while the API hangs or keeps answering
+	// with empty pages. What was collected is kept.
+	MaxIdle time.Duration
+
+	// MaxResults, if set, stops paging once this many records have been
+	// requested, e.g. to stay under paginationCap.
+	MaxResults int
+
+	// PageBuffer is how many fetched pages may wait for the consumer of
+	// Stream before fetching blocks. Zero hands each page over directly.
+	PageBuffer int
+
+	// DumpDir, if set, receives a file with the URL and whole body of
+	// every response that fails to parse (see dumperr.go)
+	DumpDir string
+
+	// WarmUp sends one discarded request before paging so connection
+	// setup doesn't land on the first page
+	WarmUp bool
+
+	// Preflight reads the result total with a one-row request before
+	// paging, instead of from the first page
+	Preflight bool
+
+	// PageTimeout bounds each page request on its own, derived from the
+	// context passed to Run. Zero uses the client's 10 second timeout.
+	PageTimeout time.Duration
+
+	// StartPage and StartOffset resume paging after StartPage pages that
+	// ended at record StartOffset, e.g. from a checkpoint
+	StartPage   int
+	StartOffset int
+}
+
+// Scraper runs searches against the BrokerCheck API. Each Scraper has its own
+// HTTP client, so several can run side by side with different settings
+// (e.g. a custom Transport in tests, or a different proxy per region).
+type Scraper struct {
+	Client *http.Client
+	Config Config
+
+	// ProgressFunc, if set, is called after each search page and each
+	// detail lookup with how far that phase has got.
+	ProgressFunc ProgressFunc
+
+	// BetweenPages, if set, is called after each search page with the
+	// number of the page just fetched and the reported total. Returning an
+	// error aborts the scrape; a positive duration is slept before the next
+	// page, on top of the normal rate limiting. With Config.Workers it may
+	// be called concurrently, and the pause holds back one worker.
+	BetweenPages func(page, total int) (time.Duration, error)
+
+	// ErrorFunc, if set, is called for every failed request, including
+	// ones that are retried or skipped. It may be called concurrently.
+	ErrorFunc func(ErrorEvent)
+
+	// Throttle, if set, is a pause shared with other Scrapers: any of them
+	// getting a 429/503 holds all of them back (-throttle-on-429-global)
+	Throttle *GlobalThrottle
+
+	// Stats describes the most recent Run
+	Stats RunStats
+
+	limiter      *adaptiveLimiter
+	sem          chan struct{} // Counting semaphore bounding in-flight requests
+	mappedFields *sync.Map     // Field-map substitutions already logged
+	bandwidth    *byteMeter    // Meters response bodies (Config.MaxBandwidth)
+}
+
+// RunStats separates what a Run tried from what it actually got, so the
+// summary stays honest when pages are retried or the scrape stops early
+type RunStats struct {
+	Reported       int // Total results the API reported
+	PagesAttempted int // Distinct pages requested
+	PagesFetched   int // Pages that came back successfully
+	Requests       int // Search requests sent, retries included
+	RecordsFetched int // Brokers received across all fetched pages
+}
+
+// add sums o into st, for runs split into several searches
+func (st *RunStats) add(o RunStats) {
+	st.PagesAttempted += o.PagesAttempted
+	st.PagesFetched += o.PagesFetched
+	st.Requests += o.Requests
+	st.RecordsFetched += o.RecordsFetched
+}
+
+// ProgressFunc reports scrape progress. phase is PhaseSearch or
+// PhaseDetail; done and total count brokers within that phase.
+type ProgressFunc func(phase string, done, total int)
+
+// Progress phases
+const (
+	PhaseSearch = "search"
+	PhaseDetail = "detail"
+)
+
+// NewScraper returns a Scraper with a default client. A zero APIURL,
+// PageSize, Delay or MaxConcurrent falls back to the package default; a
+// negative Delay means no spacing until the server pushes back.
+func NewScraper(cfg Config) *Scraper {
+	if cfg.APIURL == "" {
+		cfg.APIURL = apiURL
+	}
+	if cfg.PageSize <= 0 {
+		cfg.PageSize = pageSize
+	}
+	if cfg.Delay == 0 {
+		cfg.Delay = 1 * time.Second
+	} else if cfg.Delay < 0 {
+		cfg.Delay = 0
+	}
+	if cfg.MaxConcurrent <= 0 {
+		cfg.MaxConcurrent = 1
+	}
+	if cfg.PageBuffer < 0 {
+		cfg.PageBuffer = 0
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+	if cfg.TLS != nil || len(cfg.SourceIPs) > 0 {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = cfg.TLS
+		client.Transport = transport
+		if len(cfg.SourceIPs) > 0 {
+			client.Transport = newSourceIPTransport(transport, cfg.SourceIPs)
+		}
+	}
+	if cfg.PageTimeout > 0 {
+		// The per-page context deadline takes over from the client timeout,
+		// which would otherwise cap it at 10 seconds.
+		client.Timeout = 0
+	}
+	return &Scraper{
+		Client:       client,
+		Config:       cfg,
+		limiter:      newAdaptiveLimiter(cfg.Delay, cfg.Burst),
+		sem:          make(chan struct{}, cfg.MaxConcurrent),
+		mappedFields: &sync.Map{},
+		bandwidth:    newByteMeter(cfg.MaxBandwidth),
+	}
+}
+
+// Run pages through every result for the configured search and returns the
+// brokers collected. If a page fails or ctx is done, the brokers gathered so
+// far are returned along with the error.
+func (s *Scraper) Run(ctx context.Context) ([]BrokerSource, error) {
+	var allBrokers []BrokerSource
+	err := s.Stream(ctx, func(page []BrokerSource) error {
+		allBrokers = append(allBrokers, page...)
+		return nil
+	})
+	return allBrokers, err
+}
+
+// Stream pages through the search like Run, but hands each page to fn
+// instead of keeping it. Fetching runs at most Config.PageBuffer pages ahead
+// of fn; past that the fetcher blocks, so a slow writer caps memory rather
+// than letting pages pile up. An error from fn stops the scrape and is
+// returned.
+func (s *Scraper) Stream(ctx context.Context, fn func(page []BrokerSource) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	pages := make(chan []BrokerSource, s.Config.PageBuffer)
+	fetched := make(chan error, 1)
+	go func() {
+		err := s.fetchPages(ctx, pages)
+		close(pages)
+		fetched <- err
+	}()
+
+	var writeErr error
+	for page := range pages {
+		if writeErr != nil {
+			continue // Drain so the fetcher can exit
+		}
+		if err := fn(page); err != nil {
+			writeErr = err
+			cancel()
+		}
+	}
+	if err := <-fetched; writeErr == nil {
+		return err
+	}
+	return writeErr
+}
+
+// fetchPages is the producer half of Stream: it walks the result offsets
+// and sends each page's brokers to out
+func (s *Scraper) fetchPages(ctx context.Context, out chan<- []BrokerSource) (err error) {
+	currentPage := s.Config.StartPage
+	start := s.Config.StartOffset
+	totalResults := 0 // We'll get this from the first request
+	collected := start
+	s.Stats = RunStats{}
+
+	log.Println("Starting scrape...")
+
+	// With MaxIdle, a timer that each page with results pushes back
+	// cancels the search if it ever runs out, and the abort is reported
+	// instead of the cancellation it causes
+	idle := func() {}
+	if d := s.Config.MaxIdle; d > 0 {
+		idleErr := fmt.Errorf("no page with results arrived for %s (-max-idle); aborting the search", d)
+		var cancel context.CancelCauseFunc
+		ctx, cancel = context.WithCancelCause(ctx)
+		timer := time.AfterFunc(d, func() { cancel(idleErr) })
+		idle = func() { timer.Reset(d) }
+		defer func() {
+			timer.Stop()
+			if err != nil && context.Cause(ctx) == idleErr {
+				err = idleErr
+			}
+			cancel(nil)
+		}()
+	}
+
+	if s.Config.WarmUp {
+		s.warmUp(ctx)
+	}
+
+	// With Preflight the total comes from a one-row request, so paging
+	// starts with the end already known
+	if s.Config.Preflight {
+		total, err := s.Total(ctx)
+		if err != nil {
+			return fmt.Errorf("preflight: %w", err)
+		}
+		totalResults = total
+		s.Stats.Reported = total
+		if total == 0 {
+			log.Println("API returned 0 total results. Exiting.")
+			return nil
+		}
+		log.Printf("Found %d total results. Starting download...", total)
+		s.warnCap(total)
+	}
+
+	for {
+		// The 'start' parameter advances by however many rows each page
+		// returned. The API has no cursor or next-page token (responses carry
+		// only hits.total and hits.hits), so offset math is the only option.
+
+		// Break the loop if we've already gathered all results
+		if totalResults > 0 && start >= totalResults {
+			break
+		}
+		if start >= s.pageLimit() {
+			s.noteCap(totalResults, start)
+			break
+		}
+
+		log.Printf("Fetching page %d (starting at record %d)...", currentPage+1, start)
+
+		s.Stats.PagesAttempted++
+		want := min(s.Config.PageSize, s.pageLimit()-start)
+		response, rows, err := s.fetchAdaptive(ctx, currentPage+1, start, want)
+		if err != nil {
+			return fmt.Errorf("page %d: %w", currentPage+1, err) // Stop on error
+		}
+
+		// A 200 with no hits before the reported end is a transient API
+		// glitch, not the last page, so give that offset another go or two
+		reported := max(totalResults, response.Hits.Total)
+		for retry := 1; len(response.Hits.Hits) == 0 && start < reported && retry <= emptyPageRetries; retry++ {
+			log.Printf("Page %d came back empty at record %d of %d; retrying (%d/%d)...", currentPage+1, start, reported, retry, emptyPageRetries)
+			response, rows, err = s.fetchAdaptive(ctx, currentPage+1, start, rows)
+			if err != nil {
+				return fmt.Errorf("page %d: %w", currentPage+1, err)
+			}
+		}
+		if len(response.Hits.Hits) == 0 && start < reported {
+			logErrorf("Page %d still empty after %d retries; stopping at record %d of %d", currentPage+1, emptyPageRetries, start, reported)
+		}
+
+		s.Stats.PagesFetched++
+		s.Stats.RecordsFetched += len(response.Hits.Hits)
+
+		// Set totalResults on the first loop
+		if totalResults == 0 {
+			totalResults = response.Hits.Total
+			s.Stats.Reported = totalResults
+			if totalResults == 0 {
+				log.Println("API returned 0 total results. Exiting.")
+				break
+			}
+			log.Printf("Found %d total results. Starting download...", totalResults)
+			s.warnCap(totalResults)
+		}
+
+		// Hand this page to the writer, waiting if it's PageBuffer pages behind
+		page := s.pageOf(response)
+		select {
+		case out <- page:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		collected += len(page)
+		if len(page) > 0 {
+			idle()
+		}
+
+		if s.ProgressFunc != nil {
+			s.ProgressFunc(PhaseSearch, collected, totalResults)
+		}
+
+		// If this was the last page, stop
+		if len(response.Hits.Hits) < rows {
+			break
+		}
+
+		if s.BetweenPages != nil {
+			pause, err := s.BetweenPages(currentPage+1, totalResults)
+			if err != nil {
+				return fmt.Errorf("stopped after page %d: %w", currentPage+1, err)
+			}
+			if pause > 0 {
+				select {
+				case <-time.After(pause):
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+				idle() // A deliberate pause isn't the server stalling
+			}
+		}
+
+		if s.Config.Workers > 1 {
+			return s.fetchConcurrent(ctx, out, currentPage+1, start+rows, rows, totalResults, collected, idle)
+		}
+
+		currentPage++
+		start += rows
+		// No other sleep needed here: getJSON waits on the shared limiter,
+		// which keeps us polite. Let's not break the website!
+	}
+
+	return nil
+}
+
+// pageLimit is how far into the results paging may go: Config.MaxResults
+// if set, and never past paginationCap, where the API stops serving pages
+func (s *Scraper) pageLimit() int {
+	if s.Config.MaxResults > 0 {
+		return min(s.Config.MaxResults, paginationCap)
+	}
+	return paginationCap
+}
+
+// warnCap warns up front when a search reports more results than can be
+// paged through, unless MaxResults already accounts for it
+func (s *Scraper) warnCap(total int) {
+	if total > paginationCap && s.Config.MaxResults == 0 {
+		log.Printf("Warning: the API reported %d results, but only the first %d can be paged through; the other %d will be missing. Use -auto-subdivide (or a smaller -radius) to reach them", total, paginationCap, total-paginationCap)
+	}
+}
+
+// noteCap warns again, once paging stopped at reached, if the cap is what
+// stopped it
+func (s *Scraper) noteCap(total, reached int) {
+	if total > reached && reached >= paginationCap && s.Config.MaxResults == 0 {
+		log.Printf("Warning: stopped at the API's pagination cap of %d; %d of the %d results were out of reach", paginationCap, total-reached, total)
+	}
+}
+
+// pageOf turns a response's hits into a page of brokers. Fields are
+// derived once, here, so every consumer (per-page files included) sees the
+// same ones.
+func (s *Scraper) pageOf(response *BrokerResponse) []BrokerSource {
+	page := make([]BrokerSource, len(response.Hits.Hits))
+	for i, hit := range response.Hits.Hits {
+		page[i] = hit.Source
+		page[i].Source = s.Config.SourceLabel
+	}
+	deriveFields(page, time.Now())
+	return page
+}
+
+// fetchConcurrent finishes fetchPages with Config.Workers requests at once.
+// Once the first pages have given the total, the offsets from start on are
+// shared out rows at a time, and each page goes to out as soon as it
+// arrives, so pages can come out of order. Every worker is a copy of s
+// with its own Stats, added to s.Stats at the end.
+func (s *Scraper) fetchConcurrent(ctx context.Context, out chan<- []BrokerSource, page, start, rows, total, collected int, idle func()) error {
+	end := min(total, s.pageLimit())
+	if start >= end {
+		s.noteCap(total, end)
+		return nil
+	}
+	log.Printf("Fetching the remaining pages with %d workers...", s.Config.Workers)
+
+	// A failed page stops the others through ctx, but pages already
+	// fetched are still handed over unless parent is done too
+	parent := ctx
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	type job struct{ page, start int }
+	jobs := make(chan job)
+	var mu sync.Mutex // Guards collected and ProgressFunc
+	workers := make([]*Scraper, s.Config.Workers)
+	var wg sync.WaitGroup
+	for i := range workers {
+		w := *s
+		w.Stats = RunStats{}
+		workers[i] = &w
+		wg.Go(func() {
+			if sleepCtx(ctx, rampDelay(i, len(workers), s.Config.Ramp)) != nil {
+				return
+			}
+			for j := range jobs {
+				want := min(rows, end-j.start)
+				brokers, err := w.fetchSlot(ctx, j.page, j.start, want)
+				if err != nil {
+					cancel(fmt.Errorf("page %d: %w", j.page+1, err))
+					return
+				}
+				select {
+				case out <- brokers:
+				case <-parent.Done():
+					return
+				}
+				if len(brokers) > 0 {
+					idle()
+				}
+				mu.Lock()
+				collected += len(brokers)
+				if s.ProgressFunc != nil {
+					s.ProgressFunc(PhaseSearch, collected, total)
+				}
+				mu.Unlock()
+
+				if s.BetweenPages != nil {
+					pause, err := s.BetweenPages(j.page+1, total)
+					if err != nil {
+						cancel(fmt.Errorf("stopped after page %d: %w", j.page+1, err))
+						return
+					}
+					if sleepCtx(ctx, pause) != nil {
+						return
+					}
+					idle()
+				}
+			}
+		})
+	}
+
+feed:
+	for p, off := page, start; off < end; p, off = p+1, off+rows {
+		select {
+		case jobs <- job{p, off}:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	for _, w := range workers {
+		s.Stats.add(w.Stats)
+	}
+	if err := context.Cause(ctx); err != nil {
+		return err
+	}
+	s.noteCap(total, end)
+	return nil
+}
+
+// fetchSlot fetches want records from start as one page for
+// fetchConcurrent, in several requests if fetchAdaptive has to shrink it
+func (s *Scraper) fetchSlot(ctx context.Context, page, start, want int) ([]BrokerSource, error) {
+	log.Printf("Fetching page %d (starting at record %d)...", page+1, start)
+	s.Stats.PagesAttempted++
+	var brokers []BrokerSource
+	for off := start; off < start+want; {
+		response, rows, err := s.fetchAdaptive(ctx, page+1, off, start+want-off)
+		if err != nil {
+			return nil, err
+		}
+		for retry := 1; len(response.Hits.Hits) == 0 && retry <= emptyPageRetries; retry++ {
+			log.Printf("Page %d came back empty at record %d; retrying (%d/%d)...", page+1, off, retry, emptyPageRetries)
+			if response, rows, err = s.fetchAdaptive(ctx, page+1, off, rows); err != nil {
+				return nil, err
+			}
+		}
+		if len(response.Hits.Hits) == 0 {
+			logErrorf("Page %d still empty after %d retries at record %d", page+1, emptyPageRetries, off)
+		}
+		s.Stats.RecordsFetched += len(response.Hits.Hits)
+		brokers = append(brokers, s.pageOf(response)...)
+		if len(response.Hits.Hits) < rows {
+			break
+		}
+		off += rows
+	}
+	s.Stats.PagesFetched++
+	return brokers, nil
+}
+
+// minPageSize is the smallest page fetchAdaptive will shrink to
+const minPageSize = 25
+
+// emptyPageRetries is how many times an empty page before the reported
+// total is re-requested before we accept that the results ended early
+const emptyPageRetries = 2
+
+// warmUp sends one discarded single-row request so DNS, TCP and TLS setup
+// are paid before the first real page, keeping them out of page timings.
+// A failure is only a warning; the scrape proper reports its own errors.
+func (s *Scraper) warmUp(ctx context.Context) {
+	start := time.Now()
+	if _, err := s.Fetch(ctx, 0, 1); err != nil {
+		if ctx.Err() == nil {
+			log.Printf("Warning: warm-up request failed: %v", err)
+		}
+		return
+	}
+	log.Printf("Warm-up request took %s (connection setup included)", time.Since(start).Round(time.Millisecond))
+}
+
+// fetchAdaptive fetches the page at start, halving the page size (down to
+// minPageSize) each time the request fails in a way that suggests the
+// response was too big for the server to produce in time. It returns the
+// page size that finally worked.
+func (s *Scraper) fetchAdaptive(ctx context.Context, page, start, rows int) (*BrokerResponse, int, error) {
+	retries := 0
+	for {
+		s.Stats.Requests++
+		response, err := s.Fetch(ctx, start, rows)
+		if err == nil || ctx.Err() != nil {
+			return response, rows, err
+		}
+		s.reportError(ErrorEvent{Phase: PhaseSearch, Page: page, Offset: start, Err: err})
+		if rows > minPageSize && isLargeBodyFailure(err) {
+			smaller := max(rows/2, minPageSize)
+			log.Printf("Page at record %d failed (%v); retrying with page size %d", start, err, smaller)
+			rows = smaller
+			continue
+		}
+		// Once the page can't shrink any more, fall back on plain retries
+		retries++
+		if !s.retryWait(ctx, retries, err) {
+			return response, rows, s.retryFailure(ctx, retries, err)
+		}
+	}
+}
+
+// reportError passes ev to ErrorFunc, if one is set
+func (s *Scraper) reportError(ev ErrorEvent) {
+	if s.ErrorFunc == nil {
+		return
+	}
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	s.ErrorFunc(ev)
+}
+
+// isLargeBodyFailure reports whether err looks like the server choked on
+// the size of the response: a timeout, a truncated body, or a 413/502/504.
+func isLargeBodyFailure(err error) bool {
+	var fe *FetchError
+	if errors.As(err, &fe) && fe.Category == CategoryStatus {
+		return fe.StatusCode == http.StatusRequestEntityTooLarge ||
+			fe.StatusCode == http.StatusBadGateway ||
+			fe.StatusCode == http.StatusGatewayTimeout
+	}
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		return true
+	}
+	return errors.Is(err, context.DeadlineExceeded) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// searchQuery builds the query parameters for one page of the search
+func (s *Scraper) searchQuery(start, rows int) url.Values {
+	q := url.Values{}
+	if s.Config.Query != "" {
+		q.Set("query", s.Config.Query)
+	}
+	if s.Config.Latitude != "" {
+		q.Set("lat", s.Config.Latitude)
+		q.Set("lon", s.Config.Longitude)
+		q.Set("r", s.Config.Radius)
+	}
+	q.Set("includePrevious", strconv.FormatBool(!s.Config.OmitPrevious))
+	q.Set("hl", strconv.FormatBool(s.Config.Highlight))
+	q.Set("nrows", strconv.Itoa(rows))
+	q.Set("start", strconv.Itoa(start))
+	sort := s.Config.Sort
+	if sort == "" {
+		sort = defaultSort
+	}
+	q.Set("sort", sort)
+	q.Set("wt", "json")
+	if len(s.Config.States) > 0 {
+		q.Set("state", strings.Join(s.Config.States, ","))
+	}
+	return q
+}
+
+// defaultSort is the sort used when Config.Sort is empty
+const defaultSort = "score+desc"
+
+// Fetch performs the GET request to the API for one page of results
+func (s *Scraper) Fetch(ctx context.Context, start, rows int) (*BrokerResponse, error) {
+	q := s.searchQuery(start, rows)
+	body, err := s.getBody(ctx, s.Config.APIURL, q)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.parseResponse(body)
+	if err != nil {
+		u := s.Config.APIURL + "?" + q.Encode()
+		if path := s.dumpBody(u, body, err); path != "" {
+			err = fmt.Errorf("%w (body saved to %s)", err, path)
+		}
+		return nil, &FetchError{Category: CategoryParse, URL: u, Err: err}
+	}
+	return resp, nil
+}
+
+// Total asks the API how many brokers match the configured search without
+// downloading them: a single request for one row.
+func (s *Scraper) Total(ctx context.Context) (int, error) {
+	return s.TotalAt(ctx, s.Config.Latitude, s.Config.Longitude, s.Config.Radius)
+}
+
+// TotalAt is Total for another search point, keeping the rest of the
+// configuration (state filter, client, rate limiter)
+func (s *Scraper) TotalAt(ctx context.Context, lat, lon, radius string) (int, error) {
+	sub := *s
+	sub.Config.Latitude, sub.Config.Longitude, sub.Config.Radius = lat, lon, radius
+	response, err := sub.Fetch(ctx, 0, 1)
+	if err != nil {
+		return 0, err
+	}
+	return response.Hits.Total, nil
+}
+
+// parseResponse decodes a search response body. It is the whole parse path
+// for a page, kept separate from the HTTP code so it can be timed (or fed
+// recorded bodies) on its own.
+func (s *Scraper) parseResponse(body []byte) (*BrokerResponse, error) {
+	var brokerResponse BrokerResponse
+	if !s.Config.KeepRaw && s.Config.FieldMap == nil && !s.Config.StrictSchema {
+		if err := json.Unmarshal(body, &brokerResponse); err != nil {
+			return nil, fmt.Errorf("error unmarshaling JSON: %w", err)
+		}
+		return &brokerResponse, nil
+	}
+
+	// Keep each _source as raw bytes so it can be remapped and/or stored
+	// before it's decoded into our structs
+	var raw struct {
+		Hits struct {
+			Total int `json:"total"`
+			Hits  []struct {
+				Source json.RawMessage `json:"_source"`
+			} `json:"hits"`
+		} `json:"hits"`
+	}
+	if err := json.Unmarshal(body, &raw); err != nil {
+		return nil, fmt.Errorf("error unmarshaling JSON: %w", err)
+	}
+	brokerResponse.Hits.Total = raw.Hits.Total
+	brokerResponse.Hits.Hits = make([]BrokerHit, len(raw.Hits.Hits))
+	for i, hit := range raw.Hits.Hits {
+		if len(hit.Source) == 0 {
+			continue
+		}
+		src := hit.Source
+		if s.Config.FieldMap != nil {
+			var used []string
+			var err error
+			src, used, err = s.Config.FieldMap.Apply(src)
+			if err != nil {
+				return nil, fmt.Errorf("error applying field map: %w", err)
+			}
+			s.logMappedFields(used)
+		}
+		broker := &brokerResponse.Hits.Hits[i].Source
+		if s.Config.StrictSchema {
+			dec := json.NewDecoder(bytes.NewReader(src))
+			dec.DisallowUnknownFields()
+			if err := dec.Decode(broker); err != nil {
+				return nil, fmt.Errorf("strict schema: hit %d: %w", i, err)
+			}
+		} else if err := json.Unmarshal(src, broker); err != nil {
+			return nil, fmt.Errorf("error unmarshaling JSON: %w", err)
+		}
+		if s.Config.KeepRaw {
+			broker.Raw = hit.Source
+		}
+	}
+	return &brokerResponse, nil
+}
+
+// logMappedFields logs each field-map substitution the first time it's used
+func (s *Scraper) logMappedFields(used []string) {
+	for _, u := range used {
+		if _, seen := s.mappedFields.LoadOrStore(u, true); !seen {
+			log.Printf("Field map: reading %s (the API no longer sends the expected key)", u)
+		}
+	}
+}
+
+// getJSON requests endpoint with q and decodes the response body into v
+func (s *Scraper) getJSON(ctx context.Context, endpoint string, q url.Values, v any) error {
+	body, err := s.getBody(ctx, endpoint, q)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		u := endpoint + "?" + q.Encode()
+		if path := s.dumpBody(u, body, err); path != "" {
+			return &FetchError{Category: CategoryParse, URL: u, Err: fmt.Errorf("error unmarshaling JSON: %w (body saved to %s)", err, path)}
+		}
+		return &FetchError{Category: CategoryParse, URL: u, Err: fmt.Errorf("error unmarshaling JSON: %w. Body: %s", err, string(body))}
+	}
+	return nil
+}
+
+// getBody requests endpoint with q and returns the (decompressed) body of
+// a 200 response, leaving the decoding to the caller
+func (s *Scraper) getBody(ctx context.Context, endpoint string, q url.Values) ([]byte, error) {
+	// Take a concurrency slot, then wait our turn on the rate limiter
+	select {
+	case s.sem <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	defer func() { <-s.sem }()
+
+	// Create a new GET request
+	req, err := http.NewRequestWithContext(ctx, "GET", endpoint, nil)
+	if err != nil {
+		return nil, &FetchError{Category: CategoryNetwork, URL: endpoint, Err: err}
+	}
+	req.URL.RawQuery = q.Encode()
+
+	// Requests the transport answers locally (e.g. -cache-ttl hits) don't
+	// count against the server's rate limit
+	if ex, ok := s.Client.Transport.(rateLimitExempt); !ok || !ex.exempt(req) {
+		if err := s.Throttle.Wait(ctx); err != nil {
+			return nil, err
+		}
+		if err := s.limiter.Wait(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	// PageTimeout covers the request itself, not the time spent queued
+	// above, so slow pacing can't use it up before the request is sent
+	if s.Config.PageTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.Config.PageTimeout)
+		defer cancel()
+		req = req.WithContext(ctx)
+	}
+
+	// Set Headers
+	// Mimic the browser headers. User-Agent is often the most important.
+	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/91.0.4472.124 Safari/537.36")
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Accept-Encoding", "gzip") // Decoded by decodeBody, see gzip.go
+	if s.Config.Referer != "" {
+		req.Header.Set("Referer", s.Config.Referer)
+	}
+	if s.Config.Origin != "" {
+		req.Header.Set("Origin", s.Config.Origin)
+	}
+	for name, values := range s.Config.Headers {
+		req.Header[name] = values
+	}
+
+	// Perform the request. Errors from Do are *url.Error; the FetchError
+	// takes over naming the URL.
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		var ue *url.Error
+		if errors.As(err, &ue) {
+			err = ue.Err
+		}
+		return nil, &FetchError{Category: CategoryNetwork, URL: req.URL.String(), Err: err}
+	}
+	defer resp.Body.Close()
+
+	if s.Config.Verbose {
+		logRateLimitHeaders(resp)
+	}
+
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable {
+		s.limiter.Backoff()
+		log.Printf("Server pushed back (%d); slowing to one request every %s", resp.StatusCode, s.limiter.Interval())
+		if s.Throttle != nil && s.Throttle.Pause(s.limiter.Interval()) {
+			log.Printf("Global backoff: pausing all regions for %s", s.limiter.Interval())
+		}
+	}
+	if resp.StatusCode != 200 {
+		return nil, &FetchError{Category: CategoryStatus, StatusCode: resp.StatusCode, URL: req.URL.String(), RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())}
+	}
+
+	body, err := io.ReadAll(&meteredReader{ctx: ctx, r: resp.Body, meter: s.bandwidth})
+	if err != nil {
+		return nil, &FetchError{Category: CategoryNetwork, URL: req.URL.String(), Err: fmt.Errorf("reading body: %w", err)}
+	}
+	body, err = decodeBody(resp.Header, req.URL.String(), body)
+	if err != nil {
+		return nil, &FetchError{Category: CategoryNetwork, URL: req.URL.String(), Err: fmt.Errorf("decompressing body: %w", err)}
+	}
+
+	s.limiter.Success()
+	return body, nil
+}
+
+// logRateLimitHeaders logs any rate-limit related response headers
+// (X-RateLimit-*, RateLimit-*, Retry-After) to help tune pacing
+func logRateLimitHeaders(resp *http.Response) {
+	var found []string
+	for name, values := range resp.Header {
+		lower := strings.ToLower(name)
+		if strings.HasPrefix(lower, "x-ratelimit") || strings.HasPrefix(lower, "ratelimit") || lower == "retry-after" {
+			found = append(found, name+": "+strings.Join(values, ", "))
+		}
+	}
+	if len(found) == 0 {
+		return
+	}
+	sort.Strings(found)
+	log.Printf("Rate-limit headers (status %d): %s", resp.StatusCode, strings.Join(found, "; "))
+}
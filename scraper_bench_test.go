@@ -0,0 +1,27 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+// testdata/search_page.json is one full page (100 hits) of a search
+// response, built from the first brokers of the recorded brokers.json
+func BenchmarkUnmarshal(b *testing.B) {
+	body, err := os.ReadFile("testdata/search_page.json")
+	if err != nil {
+		b.Fatal(err)
+	}
+	s := NewScraper(Config{})
+	b.SetBytes(int64(len(body)))
+	b.ReportAllocs()
+	for b.Loop() {
+		resp, err := s.parseResponse(body)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if len(resp.Hits.Hits) != 100 {
+			b.Fatalf("got %d hits, want 100", len(resp.Hits.Hits))
+		}
+	}
+}
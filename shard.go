@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+)
+
+// Sharded Output
+// -shard-size splits the csv and ndjson outputs into files of at most N
+// brokers each (brokers-part-001.csv, brokers-part-002.csv, ...) for
+// parallel ingestion. Every CSV shard has its own header row. The other
+// formats are still written whole.
+
+// shardPath numbers a shard of the file at path: brokers.csv becomes
+// brokers-part-001.csv
+func shardPath(path string, part int) string {
+	ext := filepath.Ext(path)
+	return fmt.Sprintf("%s-part-%03d%s", strings.TrimSuffix(path, ext), part, ext)
+}
+
+// saveShards writes brokers in format with save, in shards of at most
+// size brokers, or as a single file when size is 0. An empty scrape still
+// writes one (empty) shard.
+func saveShards(format string, brokers []BrokerSource, size int, path func(format string, count int) string, save func(part []BrokerSource, path string) (int, error)) outputFiles {
+	var written outputFiles
+	if size <= 0 {
+		p := path(format, len(brokers))
+		n, err := save(brokers, p)
+		written.add(format, p, n, err)
+		return written
+	}
+
+	part := 1
+	for start := 0; start == 0 || start < len(brokers); start += size {
+		chunk := brokers[start:min(start+size, len(brokers))]
+		p := shardPath(path(format, len(chunk)), part)
+		n, err := save(chunk, p)
+		written.add(format, p, n, err)
+		part++
+	}
+	log.Printf("Sharded %s: %d brokers in %d files of up to %d", format, len(brokers), part-1, size)
+	return written
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Source Addresses
+// On a host with several addresses, -source-ips spreads requests across
+// them, each request leaving from the next address in turn, so no one
+// address carries the whole scrape.
+
+// parseSourceIPs parses a comma-separated list of local IP addresses and
+// checks each is assigned to this host, since binding to one that isn't
+// only fails later with an obscure "cannot assign requested address"
+func parseSourceIPs(list string) ([]net.IP, error) {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil, fmt.Errorf("listing local addresses: %w", err)
+	}
+	local := make(map[string]bool)
+	for _, a := range addrs {
+		if ipNet, ok := a.(*net.IPNet); ok {
+			local[ipNet.IP.String()] = true
+		}
+	}
+
+	var ips []net.IP
+	for _, s := range strings.Split(list, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, fmt.Errorf("%q is not an IP address", s)
+		}
+		if !local[ip.String()] {
+			return nil, fmt.Errorf("%s is not assigned to any network interface on this host", ip)
+		}
+		ips = append(ips, ip)
+	}
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("no addresses given")
+	}
+	return ips, nil
+}
+
+// sourceIPTransport sends each request through the next of its transports,
+// round-robin. Each transport dials from one source address and keeps its
+// own connection pool.
+type sourceIPTransport struct {
+	transports []http.RoundTripper
+	next       atomic.Uint32
+}
+
+// newSourceIPTransport builds one clone of base per address in ips
+func newSourceIPTransport(base *http.Transport, ips []net.IP) *sourceIPTransport {
+	t := &sourceIPTransport{}
+	for _, ip := range ips {
+		dialer := &net.Dialer{
+			LocalAddr: &net.TCPAddr{IP: ip},
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}
+		tr := base.Clone()
+		tr.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			conn, err := dialer.DialContext(ctx, network, addr)
+			if err != nil {
+				return nil, fmt.Errorf("dialing from source IP %s: %w", ip, err)
+			}
+			return conn, nil
+		}
+		t.transports = append(t.transports, tr)
+	}
+	return t
+}
+
+func (t *sourceIPTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	i := (t.next.Add(1) - 1) % uint32(len(t.transports))
+	return t.transports[i].RoundTrip(req)
+}
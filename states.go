@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+)
+
+// US States
+// Two-letter codes, names and approximate geographic centers for the 50
+// states plus D.C., used by -count-by-state and anything else that needs to
+// know about states without an external lookup.
+
+type usState struct {
+	Code string
+	Name string
+	Lat  string
+	Lon  string
+}
+
+var usStates = []usState{
+	{"AL", "Alabama", "32.318231", "-86.902298"},
+	{"AK", "Alaska", "63.588753", "-154.493062"},
+	{"AZ", "Arizona", "34.048928", "-111.093731"},
+	{"AR", "Arkansas", "35.201050", "-91.831833"},
+	{"CA", "California", "36.778261", "-119.417932"},
+	{"CO", "Colorado", "39.550051", "-105.782067"},
+	{"CT", "Connecticut", "41.603221", "-73.087749"},
+	{"DE", "Delaware", "38.910832", "-75.527670"},
+	{"DC", "District of Columbia", "38.905985", "-77.033418"},
+	{"FL", "Florida", "27.664827", "-81.515754"},
+	{"GA", "Georgia", "32.157435", "-82.907123"},
+	{"HI", "Hawaii", "19.898682", "-155.665857"},
+	{"ID", "Idaho", "44.068202", "-114.742041"},
+	{"IL", "Illinois", "40.633125", "-89.398528"},
+	{"IN", "Indiana", "40.551217", "-85.602364"},
+	{"IA", "Iowa", "41.878003", "-93.097702"},
+	{"KS", "Kansas", "39.011902", "-98.484246"},
+	{"KY", "Kentucky", "37.839333", "-84.270018"},
+	{"LA", "Louisiana", "31.244823", "-92.145024"},
+	{"ME", "Maine", "45.253783", "-69.445469"},
+	{"MD", "Maryland", "39.045755", "-76.641271"},
+	{"MA", "Massachusetts", "42.407211", "-71.382437"},
+	{"MI", "Michigan", "44.314844", "-85.602364"},
+	{"MN", "Minnesota", "46.729553", "-94.685900"},
+	{"MS", "Mississippi", "32.354668", "-89.398528"},
+	{"MO", "Missouri", "37.964253", "-91.831833"},
+	{"MT", "Montana", "46.879682", "-110.362566"},
+	{"NE", "Nebraska", "41.492537", "-99.901813"},
+	{"NV", "Nevada", "38.802610", "-116.419389"},
+	{"NH", "New Hampshire", "43.193852", "-71.572395"},
+	{"NJ", "New Jersey", "40.058324", "-74.405661"},
+	{"NM", "New Mexico", "34.972730", "-105.032363"},
+	{"NY", "New York", "43.299428", "-74.217933"},
+	{"NC", "North Carolina", "35.759573", "-79.019300"},
+	{"ND", "North Dakota", "47.551493", "-101.002012"},
+	{"OH", "Ohio", "40.417287", "-82.907123"},
+	{"OK", "Oklahoma", "35.007752", "-97.092877"},
+	{"OR", "Oregon", "43.804133", "-120.554201"},
+	{"PA", "Pennsylvania", "41.203322", "-77.194525"},
+	{"RI", "Rhode Island", "41.580095", "-71.477429"},
+	{"SC", "South Carolina", "33.836081", "-81.163725"},
+	{"SD", "South Dakota", "43.969515", "-99.901813"},
+	{"TN", "Tennessee", "35.517491", "-86.580447"},
+	{"TX", "Texas", "31.968599", "-99.901813"},
+	{"UT", "Utah", "39.320980", "-111.093731"},
+	{"VT", "Vermont", "44.558803", "-72.577841"},
+	{"VA", "Virginia", "37.431573", "-78.656894"},
+	{"WA", "Washington", "47.751074", "-120.740139"},
+	{"WV", "West Virginia", "38.597626", "-80.454903"},
+	{"WI", "Wisconsin", "43.784440", "-88.787868"},
+	{"WY", "Wyoming", "43.075968", "-107.290284"},
+}
+
+// printStates writes one line per state code, sorted by code, for
+// -list-states
+func printStates(w io.Writer) {
+	states := slices.Clone(usStates)
+	slices.SortFunc(states, func(a, b usState) int { return strings.Compare(a.Code, b.Code) })
+	for _, st := range states {
+		fmt.Fprintf(w, "%s %s\n", st.Code, st.Name)
+	}
+}
+
+// lookupState returns the state with the given two-letter code
+func lookupState(code string) (usState, bool) {
+	code = strings.ToUpper(code)
+	for _, st := range usStates {
+		if st.Code == code {
+			return st, true
+		}
+	}
+	return usState{}, false
+}
+
+// addStateNames sets StateName on every employment to its state's full
+// name, or to the code as given when it isn't a known state, and returns
+// how many were named and how many had a state
+func addStateNames(brokers []BrokerSource) (named, total int) {
+	fill := func(emps []Employment) {
+		for j := range emps {
+			e := &emps[j]
+			e.StateName = e.State
+			if e.State == "" {
+				continue
+			}
+			total++
+			if st, ok := lookupState(strings.TrimSpace(e.State)); ok {
+				e.StateName = st.Name
+				named++
+			}
+		}
+	}
+	for i := range brokers {
+		fill(brokers[i].CurrentEmployments)
+		fill(brokers[i].PreviousEmployments)
+	}
+	return named, total
+}
+
+// parseStateList turns a comma-separated list of state codes into a
+// normalized slice, rejecting unknown codes
+func parseStateList(list string) ([]string, error) {
+	var codes []string
+	for _, code := range strings.Split(list, ",") {
+		code = strings.TrimSpace(code)
+		if code == "" {
+			continue
+		}
+		st, ok := lookupState(code)
+		if !ok {
+			return nil, fmt.Errorf("unknown state code %q", code)
+		}
+		codes = append(codes, st.Code)
+	}
+	return codes, nil
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
+)
+
+// Streaming Reprocessing
+// -input with -stream filters and rewrites a saved scrape without loading
+// it all into memory: brokers are read a batch at a time, deduplicated
+// against every key seen so far, run through postProcess and written out
+// as csv and/or ndjson before the next batch is read. Steps that need the
+// whole set at once (shuffling, -limit-per-firm, per-firm summaries, ...)
+// aren't available this way.
+
+// streamBatchSize is how many brokers are post-processed together
+const streamBatchSize = 1000
+
+// brokerReader returns the next broker of a saved scrape, or io.EOF
+type brokerReader func() (BrokerSource, error)
+
+// newBrokerReader reads the brokers in r one at a time, from a JSON array,
+// NDJSON or CSV as loadBrokers would
+func newBrokerReader(r *bufio.Reader) (brokerReader, error) {
+	first, err := peekNonSpace(r)
+	if err == io.EOF {
+		return func() (BrokerSource, error) { return BrokerSource{}, io.EOF }, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if first != '[' && first != '{' {
+		cr, err := newCSVBrokerReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return cr.Read, nil
+	}
+
+	dec := json.NewDecoder(r)
+	if first == '[' {
+		if _, err := dec.Token(); err != nil {
+			return nil, err
+		}
+	}
+	n := 0
+	return func() (BrokerSource, error) {
+		var b BrokerSource
+		if first == '[' && !dec.More() {
+			return b, io.EOF
+		}
+		if err := dec.Decode(&b); err != nil {
+			if errors.Is(err, io.EOF) {
+				return b, io.EOF
+			}
+			return b, fmt.Errorf("record %d: %w", n+1, err)
+		}
+		n++
+		return b, nil
+	}, nil
+}
+
+// streamUnsupported names the options -stream can't honour, given as the
+// flag that sets each
+func streamUnsupported(o *options) []string {
+	var bad []string
+	for format := range o.formats {
+		if format != "csv" && format != "ndjson" {
+			bad = append(bad, "-format "+format)
+		}
+	}
+	return append(bad, batchUnsupported(o)...)
+}
+
+// batchUnsupported names the options that need every broker at once, which
+// processing a batch at a time can't honour
+func batchUnsupported(o *options) []string {
+	var bad []string
+	for _, opt := range []struct {
+		name string
+		set  bool
+	}{
+		{"-shuffle", o.Shuffle},
+		{"-geo-check", o.GeoCheck > 0},
+		{"-limit-per-firm", o.LimitPerFirm > 0},
+		{"-dedupe-report", o.DedupeReport},
+		{"-group-output-by-state", o.GroupByStateDir != ""},
+		{"-shard-size", o.ShardSize > 0},
+		{"-group-by-firm", o.GroupByFirm},
+		{"-firms-only", o.FirmsOnly},
+		{"-manifest", o.Manifest},
+		{"-tar", o.Tar != ""},
+		{"-head", o.Head > 0},
+		{"-raw", o.Raw},
+		{"-name-template with {count}", strings.Contains(o.NameTemplate, "{count}")},
+	} {
+		if opt.set {
+			bad = append(bad, opt.name)
+		}
+	}
+	return bad
+}
+
+// runStream reprocesses opts.Input batch by batch (see above) and returns
+// how many brokers were saved
+func runStream(opts *options) (int, error) {
+	in, err := openInput(opts.Input)
+	if err != nil {
+		return 0, err
+	}
+	defer in.Close()
+	next, err := newBrokerReader(bufio.NewReader(in))
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", opts.Input, err)
+	}
+
+	// The outputs stay open for the whole run and are committed at the end
+	type streamOutput struct {
+		path  string
+		out   output
+		write func(b *BrokerSource) error
+		close func() error
+	}
+	var outputs []*streamOutput
+	defer func() {
+		for _, o := range outputs {
+			o.out.Close()
+		}
+	}()
+	for _, format := range []string{"ndjson", "csv"} {
+		if !opts.formats[format] {
+			continue
+		}
+		so := &streamOutput{path: opts.outputPath(format, 0)}
+		if so.out, err = createOutput(so.path); err != nil {
+			return 0, err
+		}
+		if format == "csv" {
+			cw := newCSVRecordWriter(so.out, opts.csvOptions())
+			so.write = func(b *BrokerSource) error { cw.Write(b); return nil }
+			so.close = func() error {
+				_, err := cw.Close()
+				if opts.NoPlaceholder {
+					log.Printf("No placeholder rows: skipped %d brokers without an employment in %s", cw.skipped, describeOutput(so.path))
+				}
+				return err
+			}
+		} else {
+			enc := json.NewEncoder(so.out)
+			so.write = func(b *BrokerSource) error { return enc.Encode(jsonRecord(b, opts.numericCRD())) }
+			so.close = func() error { return nil }
+		}
+		outputs = append(outputs, so)
+	}
+
+	filter := newBatchFilter(opts)
+	var read int
+	batch := make([]BrokerSource, 0, streamBatchSize)
+	flush := func() error {
+		deriveFields(batch, time.Now())
+		kept, err := filter.filter(batch)
+		if err != nil {
+			return err
+		}
+		for i := range kept {
+			for _, so := range outputs {
+				if err := so.write(&kept[i]); err != nil {
+					return fmt.Errorf("writing %s: %w", describeOutput(so.path), err)
+				}
+			}
+		}
+		batch = batch[:0]
+		return nil
+	}
+
+	for {
+		b, err := next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return filter.saved, fmt.Errorf("%s: %w", opts.Input, err)
+		}
+		read++
+		batch = append(batch, b)
+		if len(batch) == streamBatchSize {
+			if err := flush(); err != nil {
+				return filter.saved, err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return filter.saved, err
+	}
+	filter.finish()
+
+	for _, so := range outputs {
+		if err := so.close(); err != nil {
+			return filter.saved, fmt.Errorf("writing %s: %w", describeOutput(so.path), err)
+		}
+		if err := so.out.Commit(); err != nil {
+			return filter.saved, fmt.Errorf("writing %s: %w", describeOutput(so.path), err)
+		}
+		log.Printf("Successfully saved to %s", describeOutput(so.path))
+	}
+	log.Printf("Stream: read %d records from %s, %d duplicates removed, saved %d brokers", read, opts.Input, filter.dups, filter.saved)
+	return filter.saved, nil
+}
+
+// batchFilter deduplicates and post-processes brokers a batch at a time,
+// each batch against every key seen in the ones before.
+// -warn-empty-field is judged on the running totals by finish instead of
+// batch by batch.
+type batchFilter struct {
+	opts      *options
+	logger    *log.Logger // For postProcess
+	seen      map[string]bool
+	dups      int
+	saved     int // Brokers passed so far, for -index
+	warnEmpty float64
+	empty     emptyFieldCounts
+
+	countyFound, countyTotal int // For warnCountyMisses
+}
+
+func newBatchFilter(opts *options) *batchFilter {
+	// Per-batch step logs would repeat for every batch
+	logger := log.Default()
+	if !opts.Verbose {
+		logger = log.New(io.Discard, "", 0)
+	}
+	f := &batchFilter{opts: opts, logger: logger, seen: make(map[string]bool), warnEmpty: opts.WarnEmpty}
+	if opts.WarnEmpty > 0 {
+		batchOpts := *opts
+		batchOpts.WarnEmpty = 0
+		f.opts = &batchOpts
+		f.empty = newEmptyFieldCounts()
+	}
+	return f
+}
+
+// finish logs the warnings judged over every batch
+func (f *batchFilter) finish() {
+	if f.empty != nil {
+		f.empty.warn(f.warnEmpty)
+	}
+	if f.opts.counties != nil {
+		warnCountyMisses(f.countyFound, f.countyTotal, f.opts.ZipCountyFile)
+	}
+}
+
+// filter returns the brokers of batch that are new and survive
+// postProcess, reusing batch's storage
+func (f *batchFilter) filter(batch []BrokerSource) ([]BrokerSource, error) {
+	opts := f.opts
+	if opts.NormalizeWS {
+		normalizeWhitespace(batch)
+	}
+	kept := batch[:0]
+	for _, b := range batch {
+		if key := dedupeKey(b, opts.DedupeBy); key != "" && opts.DedupeBy != dedupeByNone {
+			if f.seen[key] {
+				f.dups++
+				continue
+			}
+			f.seen[key] = true
+		}
+		kept = append(kept, b)
+	}
+	if f.empty != nil {
+		f.empty.add(kept)
+	}
+	kept = postProcess(kept, opts, f.logger)
+	applyCharset(kept, opts, f.logger)
+	if opts.counties != nil {
+		found, total := countyCoverage(kept)
+		f.countyFound += found
+		f.countyTotal += total
+	}
+	if opts.numericCRD() {
+		if err := checkNumericCRDs(kept); err != nil {
+			return nil, fmt.Errorf("-crd-type number: %w", err)
+		}
+	}
+	for i := range kept {
+		f.saved++
+		if opts.Index {
+			kept[i].Index = f.saved
+		}
+	}
+	return kept, nil
+}
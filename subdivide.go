@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Radius Subdivision
+// The API (Elasticsearch underneath) won't page past roughly 10,000
+// results, so a dense metro can't be fully listed from one search. With
+// -auto-subdivide, a circle reporting more than that is replaced by seven
+// smaller overlapping circles (one in the middle, six around it), each of
+// which is checked and split again as needed. Overlaps are removed by the
+// normal dedup.
+
+const (
+	// paginationCap is the deepest offset the API will serve
+	paginationCap = 10000
+
+	// A circle is never split into circles smaller than Config.MinRadius
+	// (default minSubdivideRadius, in miles) or past this depth; it is
+	// scraped as far as the cap allows, with a warning
+	minSubdivideRadius = 0.5
+	maxSubdivideDepth  = 6
+
+	// Child circles are a little over half the parent's radius, so the seven
+	// of them cover it with some overlap (exactly half just touches)
+	childRadiusFactor = 0.55
+	milesPerDegreeLat = 69.0
+)
+
+// Values accepted by -radius-unit. The API's r parameter is always miles.
+const (
+	unitMiles      = "mi"
+	unitKilometers = "km"
+	kmPerMile      = 1.609344
+)
+
+// toMiles converts a distance in unit to miles
+func toMiles(d float64, unit string) float64 {
+	if unit == unitKilometers {
+		return d / kmPerMile
+	}
+	return d
+}
+
+// circle is one search area
+type circle struct {
+	Lat, Lon, Radius float64
+}
+
+// children returns the seven circles covering c: its center plus six
+// around it at sqrt(3)/2 of the radius
+func (c circle) children() []circle {
+	r := c.Radius * childRadiusFactor
+	d := c.Radius * math.Sqrt(3) / 2
+	kids := []circle{{c.Lat, c.Lon, r}}
+	for i := 0; i < 6; i++ {
+		angle := float64(i) * math.Pi / 3
+		dLat := d * math.Cos(angle) / milesPerDegreeLat
+		dLon := d * math.Sin(angle) / (milesPerDegreeLat * math.Cos(c.Lat*math.Pi/180))
+		kids = append(kids, circle{c.Lat + dLat, c.Lon + dLon, r})
+	}
+	return kids
+}
+
+func (c circle) String() string {
+	return fmt.Sprintf("%.5f,%.5f r=%s", c.Lat, c.Lon, formatRadius(c.Radius))
+}
+
+// formatRadius keeps whole-mile radii looking like the default ("25")
+func formatRadius(r float64) string {
+	return strconv.FormatFloat(math.Round(r*100)/100, 'f', -1, 64)
+}
+
+// RunSubdivided scrapes the configured search, splitting any circle whose
+// total is over the pagination cap (see above). Each circle small enough to
+// page through is handed to leaf (e.g. Scraper.Run) as a copy of s aimed at
+// that circle, numbered in the order scraped. s.Stats sums every leaf.
+func (s *Scraper) RunSubdivided(ctx context.Context, leaf func(sub *Scraper, cell int) ([]BrokerSource, error)) ([]BrokerSource, error) {
+	root, err := s.circle()
+	if err != nil {
+		return nil, err
+	}
+
+	minRadius := s.Config.MinRadius
+	if minRadius == 0 {
+		minRadius = minSubdivideRadius
+	}
+
+	var all []BrokerSource
+	var errs []error
+	stats := RunStats{}
+	cells, uncovered := 0, 0
+
+	var walk func(c circle, depth int) error
+	walk = func(c circle, depth int) error {
+		sub := *s
+		sub.Config.Latitude = strconv.FormatFloat(c.Lat, 'f', 6, 64)
+		sub.Config.Longitude = strconv.FormatFloat(c.Lon, 'f', 6, 64)
+		sub.Config.Radius = formatRadius(c.Radius)
+
+		total, err := sub.Total(ctx)
+		if err != nil {
+			return fmt.Errorf("counting %s: %w", c, err)
+		}
+		if depth == 0 {
+			stats.Reported = total
+		}
+		indent := strings.Repeat("  ", depth)
+		if total > paginationCap {
+			if depth < maxSubdivideDepth && c.Radius*childRadiusFactor >= minRadius {
+				log.Printf("Subdivide: %s%s: %d results, over the %d cap; splitting into 7", indent, c, total, paginationCap)
+				for _, kid := range c.children() {
+					if err := walk(kid, depth+1); err != nil {
+						if ctx.Err() != nil {
+							return err
+						}
+						errs = append(errs, err)
+					}
+				}
+				return nil
+			}
+			log.Printf("Warning: subdivide: %s%s: %d results but at the -min-radius or depth limit; this area can't be fully covered, only the first %d are reachable", indent, c, total, paginationCap)
+			uncovered += total - paginationCap
+			sub.Config.MaxResults = paginationCap // Warned about here already
+		}
+
+		cells++
+		log.Printf("Subdivide: %s%s: %d results; scraping as cell %d", indent, c, total, cells)
+		if total == 0 {
+			return nil
+		}
+		brokers, err := leaf(&sub, cells)
+		all = append(all, brokers...)
+		stats.add(sub.Stats)
+		if err != nil {
+			return fmt.Errorf("cell %d (%s): %w", cells, c, err)
+		}
+		return nil
+	}
+
+	err = walk(root, 0)
+	s.Stats = stats
+	log.Printf("Subdivide: covered the search with %d cells", cells)
+	if uncovered > 0 {
+		log.Printf("Warning: subdivide: about %d results were out of reach in cells at the split limit", uncovered)
+	}
+	return all, errors.Join(append(errs, err)...)
+}
+
+// circle parses the configured search area
+func (s *Scraper) circle() (circle, error) {
+	lat, err := strconv.ParseFloat(s.Config.Latitude, 64)
+	if err != nil {
+		return circle{}, fmt.Errorf("latitude %q: %w", s.Config.Latitude, err)
+	}
+	lon, err := strconv.ParseFloat(s.Config.Longitude, 64)
+	if err != nil {
+		return circle{}, fmt.Errorf("longitude %q: %w", s.Config.Longitude, err)
+	}
+	r, err := strconv.ParseFloat(s.Config.Radius, 64)
+	if err != nil {
+		return circle{}, fmt.Errorf("radius %q: %w", s.Config.Radius, err)
+	}
+	return circle{lat, lon, r}, nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Tar Output
+// -tar bundles every file a run wrote (outputs and manifest) into one tar
+// stream on stdout, for jobs that ship a single artifact: `... -tar tgz |
+// tar xz`.
+
+// tarFormats lists every value accepted by -tar
+var tarFormats = []string{"tar", "tgz"}
+
+// writeTar writes the named files into a tar archive on w, gzipped when
+// gz is set. Paths are stored as given, with forward slashes.
+func writeTar(w io.Writer, paths []string, gz bool) error {
+	if gz {
+		zw := gzip.NewWriter(w)
+		if err := writeTar(zw, paths, false); err != nil {
+			return err
+		}
+		return zw.Close()
+	}
+
+	tw := tar.NewWriter(w)
+	for _, path := range paths {
+		if err := addTarFile(tw, path); err != nil {
+			return fmt.Errorf("adding %s: %w", path, err)
+		}
+	}
+	return tw.Close()
+}
+
+// addTarFile copies one regular file into tw
+func addTarFile(tw *tar.Writer, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	hdr, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+	hdr.Name = filepath.ToSlash(path)
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, file)
+	return err
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Test Records
+// The API occasionally returns obvious test or placeholder records, such
+// as a broker named TEST at a firm called "Test Firm", or CRD 0.
+// -drop-test-records removes brokers matching a blocklist of patterns
+// before output; -test-patterns replaces the built-in list.
+
+// testPattern is one blocklist entry: a field and a regexp that must match
+// all of its value, case-insensitively
+type testPattern struct {
+	Field string
+	re    *regexp.Regexp
+}
+
+// Fields a test pattern can match. firm matches if any current or previous
+// employment's firm name does.
+var testPatternFields = []string{"crd", "first", "last", "firm"}
+
+// defaultTestPatterns is the built-in blocklist, as field,pattern rows
+var defaultTestPatterns = [][2]string{
+	{"crd", `0+`},
+	{"first", `test|dummy|placeholder`},
+	{"last", `test|dummy|placeholder`},
+	{"firm", `test( firm| company| broker(age)?)?`},
+	{"firm", `(do not use|placeholder|dummy)\b.*`},
+}
+
+// compileTestPatterns checks and compiles field,pattern rows
+func compileTestPatterns(rows [][2]string) ([]testPattern, error) {
+	patterns := make([]testPattern, 0, len(rows))
+	for _, row := range rows {
+		field := strings.ToLower(strings.TrimSpace(row[0]))
+		if !slices.Contains(testPatternFields, field) {
+			return nil, fmt.Errorf("unknown field %q (use %s)", row[0], strings.Join(testPatternFields, ", "))
+		}
+		re, err := regexp.Compile(`(?i)^(?:` + strings.TrimSpace(row[1]) + `)$`)
+		if err != nil {
+			return nil, fmt.Errorf("%s pattern %q: %v", field, row[1], err)
+		}
+		patterns = append(patterns, testPattern{Field: field, re: re})
+	}
+	return patterns, nil
+}
+
+// loadTestPatterns reads a CSV of field,pattern rows. Blank lines and
+// lines starting with # are skipped.
+func loadTestPatterns(filename string) ([]testPattern, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.Comment = '#'
+	reader.FieldsPerRecord = 2
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	rows := make([][2]string, len(records))
+	for i, rec := range records {
+		rows[i] = [2]string{rec[0], rec[1]}
+	}
+	return compileTestPatterns(rows)
+}
+
+// isTestRecord reports whether b matches any of the patterns. Values are
+// trimmed first, and empty values never match.
+func isTestRecord(b BrokerSource, patterns []testPattern) bool {
+	match := func(re *regexp.Regexp, s string) bool {
+		s = strings.TrimSpace(s)
+		return s != "" && re.MatchString(s)
+	}
+	for _, p := range patterns {
+		switch p.Field {
+		case "crd":
+			if match(p.re, b.CRD) {
+				return true
+			}
+		case "first":
+			if match(p.re, b.FirstName) {
+				return true
+			}
+		case "last":
+			if match(p.re, b.LastName) {
+				return true
+			}
+		case "firm":
+			for _, emps := range [][]Employment{b.CurrentEmployments, b.PreviousEmployments} {
+				for _, e := range emps {
+					if match(p.re, e.FirmName) {
+						return true
+					}
+				}
+			}
+		}
+	}
+	return false
+}
+
+// dropTestRecords removes the brokers isTestRecord matches, keeping the
+// order of the rest. It filters in place.
+func dropTestRecords(brokers []BrokerSource, patterns []testPattern) []BrokerSource {
+	kept := brokers[:0]
+	for _, b := range brokers {
+		if !isTestRecord(b, patterns) {
+			kept = append(kept, b)
+		}
+	}
+	return kept
+}
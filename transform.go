@@ -0,0 +1,520 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Post-processing
+// These steps run on the collected brokers after the scrape and before any
+// output is written, so every format sees the same data.
+
+// normalizeWhitespace trims and collapses runs of whitespace in the name
+// and firm name fields of every broker
+func normalizeWhitespace(brokers []BrokerSource) {
+	for i := range brokers {
+		b := &brokers[i]
+		b.FirstName = collapseSpaces(b.FirstName)
+		b.LastName = collapseSpaces(b.LastName)
+		for j := range b.CurrentEmployments {
+			b.CurrentEmployments[j].FirmName = collapseSpaces(b.CurrentEmployments[j].FirmName)
+		}
+		for j := range b.PreviousEmployments {
+			b.PreviousEmployments[j].FirmName = collapseSpaces(b.PreviousEmployments[j].FirmName)
+		}
+	}
+}
+
+// collapseSpaces trims s and replaces each internal run of whitespace with
+// a single space
+func collapseSpaces(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// highlightTag matches the markup the API adds around matched terms with
+// hl=true
+var highlightTag = regexp.MustCompile(`(?i)</?em>`)
+
+// stripHighlights removes highlight markup from every string field we
+// output and returns how many fields changed
+func stripHighlights(brokers []BrokerSource) int {
+	changed := 0
+	strip := func(s *string) {
+		if clean := highlightTag.ReplaceAllString(*s, ""); clean != *s {
+			*s = clean
+			changed++
+		}
+	}
+	for i := range brokers {
+		b := &brokers[i]
+		strip(&b.CRD)
+		strip(&b.FirstName)
+		strip(&b.LastName)
+		strip(&b.IndustryStartDate)
+		for _, emps := range [][]Employment{b.CurrentEmployments, b.PreviousEmployments} {
+			for j := range emps {
+				e := &emps[j]
+				strip(&e.FirmCRD)
+				strip(&e.FirmName)
+				strip(&e.City)
+				strip(&e.State)
+				strip(&e.Zip)
+			}
+		}
+	}
+	return changed
+}
+
+// normalizeZips rewrites every employment ZIP with normalizeZip and
+// returns how many changed
+func normalizeZips(brokers []BrokerSource, keepPlus4 bool) int {
+	changed := 0
+	fix := func(emps []Employment) {
+		for j := range emps {
+			if z := normalizeZip(emps[j].Zip, keepPlus4); z != emps[j].Zip {
+				emps[j].Zip = z
+				changed++
+			}
+		}
+	}
+	for i := range brokers {
+		fix(brokers[i].CurrentEmployments)
+		fix(brokers[i].PreviousEmployments)
+	}
+	return changed
+}
+
+// normalizeZip restores leading zeros the API (or a spreadsheet) dropped,
+// so "2108" becomes "02108". ZIP+4 values become "02108-1234" with
+// keepPlus4 and are cut to five digits otherwise. Anything that isn't 1-9
+// digits once the dash is removed is returned unchanged.
+func normalizeZip(zip string, keepPlus4 bool) string {
+	digits := strings.ReplaceAll(strings.TrimSpace(zip), "-", "")
+	if digits == "" || len(digits) > 9 || strings.Trim(digits, "0123456789") != "" {
+		return zip
+	}
+	if len(digits) <= 5 {
+		return fmt.Sprintf("%05s", digits)
+	}
+	digits = fmt.Sprintf("%09s", digits)
+	if !keepPlus4 {
+		return digits[:5]
+	}
+	return digits[:5] + "-" + digits[5:]
+}
+
+// dateLayouts are the formats we have seen (or expect) for API dates
+var dateLayouts = []string{"2006-01-02", "01/02/2006", time.RFC3339}
+
+// parseAPIDate parses a date string from the API
+func parseAPIDate(s string) (time.Time, bool) {
+	s = strings.TrimSpace(s)
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// parseSince turns a -registered-since value into a cutoff time. It accepts
+// a date (2024-01-31), a Go duration (720h), or a count of days, weeks or
+// years (90d, 6w, 2y) measured back from now.
+func parseSince(s string, now time.Time) (time.Time, error) {
+	if t, ok := parseAPIDate(s); ok {
+		return t, nil
+	}
+	if n := len(s); n > 1 {
+		if count, err := strconv.Atoi(s[:n-1]); err == nil && count >= 0 {
+			switch s[n-1] {
+			case 'd':
+				return now.AddDate(0, 0, -count), nil
+			case 'w':
+				return now.AddDate(0, 0, -7*count), nil
+			case 'y':
+				return now.AddDate(-count, 0, 0), nil
+			}
+		}
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return time.Time{}, fmt.Errorf("%q is not a date, duration, or count like 90d/2y", s)
+	}
+	return now.Add(-d), nil
+}
+
+// filterRegisteredSince keeps brokers whose industry start date is on or
+// after cutoff. Brokers with a missing or unparseable date are kept only if
+// keepUndated is set. It returns the kept brokers and how many of them had
+// no usable date.
+func filterRegisteredSince(brokers []BrokerSource, cutoff time.Time, keepUndated bool) ([]BrokerSource, int) {
+	kept := brokers[:0]
+	undated := 0
+	for _, b := range brokers {
+		t, ok := parseAPIDate(b.IndustryStartDate)
+		if !ok {
+			undated++
+			if keepUndated {
+				kept = append(kept, b)
+			}
+			continue
+		}
+		if !t.Before(cutoff) {
+			kept = append(kept, b)
+		}
+	}
+	return kept, undated
+}
+
+// filterMinFirms keeps brokers with at least min current employments
+func filterMinFirms(brokers []BrokerSource, min int) []BrokerSource {
+	kept := brokers[:0]
+	for _, b := range brokers {
+		if len(b.CurrentEmployments) >= min {
+			kept = append(kept, b)
+		}
+	}
+	return kept
+}
+
+// filterHasPrevious keeps brokers with at least one previous employment
+func filterHasPrevious(brokers []BrokerSource) []BrokerSource {
+	kept := brokers[:0]
+	for _, b := range brokers {
+		if len(b.PreviousEmployments) > 0 {
+			kept = append(kept, b)
+		}
+	}
+	return kept
+}
+
+// Modes accepted by -verify-crd-format
+const (
+	crdCheckLog  = "log"
+	crdCheckDrop = "drop"
+)
+
+// Values accepted by -crd-type
+const (
+	crdTypeString = "string"
+	crdTypeNumber = "number"
+)
+
+// crdPattern is what a CRD looks like: a plain run of digits
+var crdPattern = regexp.MustCompile(`^[0-9]{1,10}$`)
+
+// verifyCRDs logs every broker whose CRD isn't numeric and, if drop is set,
+// removes them. It returns the brokers kept and the number malformed.
+func verifyCRDs(brokers []BrokerSource, drop bool) ([]BrokerSource, int) {
+	kept := brokers[:0]
+	malformed := 0
+	for _, b := range brokers {
+		if !crdPattern.MatchString(b.CRD) {
+			malformed++
+			logErrorf("Malformed CRD %q for %s %s", b.CRD, b.FirstName, b.LastName)
+			if drop {
+				continue
+			}
+		}
+		kept = append(kept, b)
+	}
+	return kept, malformed
+}
+
+// emptyFieldChecks are the fields -warn-empty-field watches. Broker fields
+// are counted per broker, firm fields per current employment.
+var emptyFieldChecks = []struct {
+	Name   string
+	Broker func(b *BrokerSource) string
+	Emp    func(e *Employment) string
+}{
+	{Name: "ind_source_id", Broker: func(b *BrokerSource) string { return b.CRD }},
+	{Name: "ind_firstname", Broker: func(b *BrokerSource) string { return b.FirstName }},
+	{Name: "ind_lastname", Broker: func(b *BrokerSource) string { return b.LastName }},
+	{Name: "ind_industry_cal_date", Broker: func(b *BrokerSource) string { return b.IndustryStartDate }},
+	{Name: "firm_id", Emp: func(e *Employment) string { return e.FirmCRD }},
+	{Name: "firm_name", Emp: func(e *Employment) string { return e.FirmName }},
+	{Name: "branch_state", Emp: func(e *Employment) string { return e.State }},
+}
+
+// warnEmptyFields logs a warning for every field that is empty in more
+// than threshold (0-1) of the records, a sign the API's schema moved and
+// we've stopped parsing it. It returns the names of the fields warned about.
+func warnEmptyFields(brokers []BrokerSource, threshold float64) []string {
+	counts := newEmptyFieldCounts()
+	counts.add(brokers)
+	return counts.warn(threshold)
+}
+
+// emptyFieldCounts tallies, for each of emptyFieldChecks, how many values
+// were checked and how many of them were empty
+type emptyFieldCounts []struct{ empty, total int }
+
+func newEmptyFieldCounts() emptyFieldCounts {
+	return make(emptyFieldCounts, len(emptyFieldChecks))
+}
+
+// add counts the fields of brokers
+func (c emptyFieldCounts) add(brokers []BrokerSource) {
+	for k, check := range emptyFieldChecks {
+		for i := range brokers {
+			if check.Broker != nil {
+				c[k].total++
+				if strings.TrimSpace(check.Broker(&brokers[i])) == "" {
+					c[k].empty++
+				}
+				continue
+			}
+			for j := range brokers[i].CurrentEmployments {
+				c[k].total++
+				if strings.TrimSpace(check.Emp(&brokers[i].CurrentEmployments[j])) == "" {
+					c[k].empty++
+				}
+			}
+		}
+	}
+}
+
+// warn does the logging of warnEmptyFields for the counts so far
+func (c emptyFieldCounts) warn(threshold float64) []string {
+	var flagged []string
+	for k, check := range emptyFieldChecks {
+		empty, total := c[k].empty, c[k].total
+		if total == 0 {
+			continue
+		}
+		if ratio := float64(empty) / float64(total); ratio > threshold {
+			log.Printf("Warning: %s is empty in %.0f%% of records (%d of %d); the API response may have changed shape",
+				check.Name, ratio*100, empty, total)
+			flagged = append(flagged, check.Name)
+		}
+	}
+	return flagged
+}
+
+// Dedup keys accepted by -dedupe-by
+const (
+	dedupeByCRD  = "crd"
+	dedupeByName = "name"
+	dedupeByNone = "none"
+)
+
+// dedupeKey returns the key brokers are compared on, or "" if the broker
+// can't be keyed (it is then always kept)
+func dedupeKey(b BrokerSource, by string) string {
+	switch by {
+	case dedupeByCRD:
+		return b.CRD
+	case dedupeByName:
+		var firm string
+		if len(b.CurrentEmployments) > 0 {
+			firm = b.CurrentEmployments[0].FirmName
+		}
+		if b.FirstName == "" && b.LastName == "" {
+			return ""
+		}
+		return strings.ToLower(b.FirstName + "\x00" + b.LastName + "\x00" + firm)
+	}
+	return ""
+}
+
+// dedupe drops brokers whose key (see dedupeKey) was already seen, keeping
+// the first occurrence and the original order. It returns the unique
+// brokers and the number of duplicates removed.
+func dedupe(brokers []BrokerSource, by string) ([]BrokerSource, int) {
+	if by == dedupeByNone {
+		return brokers, 0
+	}
+	seen := make(map[string]struct{}, len(brokers))
+	kept := brokers[:0]
+	for _, b := range brokers {
+		key := dedupeKey(b, by)
+		if key != "" {
+			if _, dup := seen[key]; dup {
+				continue
+			}
+			seen[key] = struct{}{}
+		}
+		kept = append(kept, b)
+	}
+	return kept, len(brokers) - len(kept)
+}
+
+// profileURLPrefix is where BrokerCheck shows an individual's profile
+const profileURLPrefix = "https://brokercheck.finra.org/individual/summary/"
+
+// deriveFields fills in the fields we compute from the API data rather
+// than read from it. The scraper runs it on each page as it arrives.
+func deriveFields(brokers []BrokerSource, now time.Time) {
+	for i := range brokers {
+		b := &brokers[i]
+		b.NumCurrentFirms = len(b.CurrentEmployments)
+		for j := range b.CurrentEmployments {
+			b.CurrentEmployments[j].IsCurrent = true
+		}
+		for j := range b.PreviousEmployments {
+			b.PreviousEmployments[j].IsCurrent = false
+		}
+		if b.CRD != "" {
+			b.ProfileURL = profileURLPrefix + b.CRD
+		}
+	}
+}
+
+// yearsExperience returns the years from b's industry start date to now,
+// or the YearsExperience it already has (e.g. read from a CSV, which has
+// no start date) if the date is missing
+func yearsExperience(b *BrokerSource, now time.Time) float64 {
+	if start, ok := parseAPIDate(b.IndustryStartDate); ok && start.Before(now) {
+		return now.Sub(start).Hours() / 24 / 365.25
+	}
+	return b.YearsExperience
+}
+
+// addYearsExperience sets YearsExperience, for -years-experience
+func addYearsExperience(brokers []BrokerSource, now time.Time) {
+	for i := range brokers {
+		brokers[i].YearsExperience = yearsExperience(&brokers[i], now)
+	}
+}
+
+// filterStates keeps brokers with at least one current employment in one
+// of the given states
+func filterStates(brokers []BrokerSource, states map[string]bool) []BrokerSource {
+	kept := brokers[:0]
+	for _, b := range brokers {
+		for _, emp := range b.CurrentEmployments {
+			if states[strings.ToUpper(emp.State)] {
+				kept = append(kept, b)
+				break
+			}
+		}
+	}
+	return kept
+}
+
+// firmNameKey is how raw firm names are matched against the -firm-map
+// table: case-insensitive with whitespace collapsed
+func firmNameKey(name string) string {
+	return strings.ToLower(collapseSpaces(name))
+}
+
+// loadFirmMap reads a CSV of raw,canonical firm name pairs. Blank lines
+// and lines starting with # are skipped.
+func loadFirmMap(filename string) (map[string]string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.Comment = '#'
+	reader.FieldsPerRecord = 2
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	firmMap := make(map[string]string, len(records))
+	for _, rec := range records {
+		firmMap[firmNameKey(rec[0])] = strings.TrimSpace(rec[1])
+	}
+	return firmMap, nil
+}
+
+// canonicalizeFirms replaces firm names found in firmMap with their
+// canonical form; unmapped names pass through unchanged. It returns the
+// number of names replaced.
+func canonicalizeFirms(brokers []BrokerSource, firmMap map[string]string) int {
+	replaced := 0
+	apply := func(emps []Employment) {
+		for i := range emps {
+			if canon, ok := firmMap[firmNameKey(emps[i].FirmName)]; ok && canon != emps[i].FirmName {
+				emps[i].FirmName = canon
+				replaced++
+			}
+		}
+	}
+	for i := range brokers {
+		apply(brokers[i].CurrentEmployments)
+		apply(brokers[i].PreviousEmployments)
+	}
+	return replaced
+}
+
+// limitPerFirm keeps at most limit brokers per firm, in encounter order. A
+// broker counts toward its first current firm; brokers with no current firm
+// are never capped.
+func limitPerFirm(brokers []BrokerSource, limit int) []BrokerSource {
+	counts := make(map[string]int)
+	kept := brokers[:0]
+	for _, b := range brokers {
+		if len(b.CurrentEmployments) > 0 {
+			key := firmKey(b.CurrentEmployments[0])
+			if counts[key] >= limit {
+				continue
+			}
+			counts[key]++
+		}
+		kept = append(kept, b)
+	}
+	return kept
+}
+
+// compactEmployments drops repeated firms from each broker's current
+// employments (by firm CRD, or name when the CRD is missing), keeping the
+// first entry. It returns the number of entries removed.
+func compactEmployments(brokers []BrokerSource) int {
+	removed := 0
+	for i := range brokers {
+		emps := brokers[i].CurrentEmployments
+		seen := make(map[string]bool, len(emps))
+		kept := emps[:0]
+		for _, emp := range emps {
+			key := firmKey(emp)
+			if seen[key] {
+				removed++
+				continue
+			}
+			seen[key] = true
+			kept = append(kept, emp)
+		}
+		brokers[i].CurrentEmployments = kept
+		brokers[i].NumCurrentFirms = len(kept)
+	}
+	return removed
+}
+
+// capEmployments keeps the first n current and the first n previous
+// employments of each broker. NumCurrentFirms is left alone, so it still
+// counts every current employment. It returns the number of entries
+// removed.
+func capEmployments(brokers []BrokerSource, n int) int {
+	removed := 0
+	for i := range brokers {
+		b := &brokers[i]
+		if len(b.CurrentEmployments) > n {
+			removed += len(b.CurrentEmployments) - n
+			b.CurrentEmployments = b.CurrentEmployments[:n]
+		}
+		if len(b.PreviousEmployments) > n {
+			removed += len(b.PreviousEmployments) - n
+			b.PreviousEmployments = b.PreviousEmployments[:n]
+		}
+	}
+	return removed
+}
+
+// numberBrokers sets each broker's Index to its 1-based position
+func numberBrokers(brokers []BrokerSource) {
+	for i := range brokers {
+		brokers[i].Index = i + 1
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// Watchlist Matching
+// -match-names-file keeps only brokers whose name is close to one on a
+// watchlist, allowing for typos and spelling variants. Names are compared
+// with Jaro-Winkler similarity after normalizing case and punctuation.
+
+// watchName is one watchlist entry, split into the parts we compare
+type watchName struct {
+	Entry string // As written in the file, reported on matches
+	First string // Normalized
+	Last  string // Normalized
+}
+
+// loadWatchlist reads one name per line, as "First Last" (the last word is
+// the last name) or "Last, First" for multi-word last names. Blank lines
+// and lines starting with # are ignored.
+func loadWatchlist(filename string) ([]watchName, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var names []watchName
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		entry := strings.TrimSpace(scanner.Text())
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+		var first, last string
+		if l, f, ok := strings.Cut(entry, ","); ok {
+			first, last = f, l
+		} else if i := strings.LastIndexFunc(entry, unicode.IsSpace); i >= 0 {
+			first, last = entry[:i], entry[i+1:]
+		}
+		w := watchName{Entry: entry, First: normalizeName(first), Last: normalizeName(last)}
+		if w.First == "" || w.Last == "" {
+			return nil, fmt.Errorf("%s:%d: %q needs both a first and a last name", filename, line, entry)
+		}
+		names = append(names, w)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("%s: no names", filename)
+	}
+	return names, nil
+}
+
+// matchWatchlist keeps the brokers whose name scores at least threshold
+// against some watchlist name, recording the best match on each. The score
+// is the mean of the first-name and last-name similarities, so a shared
+// first name alone can't make a match.
+func matchWatchlist(brokers []BrokerSource, names []watchName, threshold float64) []BrokerSource {
+	var kept []BrokerSource
+	for _, b := range brokers {
+		first, last := normalizeName(b.FirstName), normalizeName(b.LastName)
+		if first == "" && last == "" {
+			continue
+		}
+		best, bestScore := -1, 0.0
+		for i, w := range names {
+			score := (jaroWinkler(first, w.First) + jaroWinkler(last, w.Last)) / 2
+			if score > bestScore {
+				best, bestScore = i, score
+			}
+		}
+		if best >= 0 && bestScore >= threshold {
+			b.WatchlistMatch = names[best].Entry
+			b.WatchlistScore = bestScore
+			kept = append(kept, b)
+		}
+	}
+	return kept
+}
+
+// normalizeName lower-cases a name, drops punctuation (O'Brien → obrien)
+// and collapses whitespace
+func normalizeName(name string) string {
+	var sb strings.Builder
+	for _, r := range strings.ToLower(name) {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			sb.WriteRune(r)
+		case unicode.IsSpace(r) || r == '-':
+			sb.WriteRune(' ')
+		}
+	}
+	return strings.Join(strings.Fields(sb.String()), " ")
+}
+
+// jaroWinkler returns the Jaro-Winkler similarity of a and b, from 0 (no
+// resemblance) to 1 (identical). A shared prefix of up to 4 characters
+// raises the score, since name typos tend to come later in the name.
+func jaroWinkler(a, b string) float64 {
+	s1, s2 := []rune(a), []rune(b)
+	if len(s1) == 0 && len(s2) == 0 {
+		return 1
+	}
+	if len(s1) == 0 || len(s2) == 0 {
+		return 0
+	}
+
+	// Characters match if equal and no further apart than window
+	window := max(max(len(s1), len(s2))/2-1, 0)
+	matched1 := make([]bool, len(s1))
+	matched2 := make([]bool, len(s2))
+	matches := 0
+	for i := range s1 {
+		lo, hi := max(i-window, 0), min(i+window+1, len(s2))
+		for j := lo; j < hi; j++ {
+			if !matched2[j] && s1[i] == s2[j] {
+				matched1[i], matched2[j] = true, true
+				matches++
+				break
+			}
+		}
+	}
+	if matches == 0 {
+		return 0
+	}
+
+	// Transpositions are matched characters that appear in a different order
+	transpositions, j := 0, 0
+	for i := range s1 {
+		if !matched1[i] {
+			continue
+		}
+		for !matched2[j] {
+			j++
+		}
+		if s1[i] != s2[j] {
+			transpositions++
+		}
+		j++
+	}
+
+	m := float64(matches)
+	jaro := (m/float64(len(s1)) + m/float64(len(s2)) + (m-float64(transpositions/2))/m) / 3
+
+	prefix := 0
+	for prefix < min(4, len(s1), len(s2)) && s1[prefix] == s2[prefix] {
+		prefix++
+	}
+	return jaro + float64(prefix)*0.1*(1-jaro)
+}
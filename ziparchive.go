@@ -0,0 +1,60 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// ZIP Output
+// -state-zip packages a -group-output-by-state run as one .zip, so the
+// per-state files can be handed over as a single download and opened one
+// state at a time. It holds the same files -tar would, manifest included.
+
+// saveZip writes the named files into a zip archive at filename. Paths
+// are stored as given, with forward slashes, matching manifest.json.
+func saveZip(paths []string, filename string) error {
+	out, err := createOutput(filename)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	zw := zip.NewWriter(out)
+	for _, path := range paths {
+		if err := addZipFile(zw, path); err != nil {
+			return fmt.Errorf("adding %s: %w", path, err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	return out.Commit()
+}
+
+// addZipFile deflates one regular file into zw
+func addZipFile(zw *zip.Writer, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	hdr, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	hdr.Name = filepath.ToSlash(path)
+	hdr.Method = zip.Deflate
+	w, err := zw.CreateHeader(hdr)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, file)
+	return err
+}